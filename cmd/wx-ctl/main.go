@@ -0,0 +1,178 @@
+/*
+* Copyright (C) 2020 Nick Naumenko (https://gitlab.com/nnaumenko)
+* All rights reserved.
+* This software may be modified and distributed under the terms
+* of the MIT license. See the LICENSE file for details.
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/nnaumenko/wx/internal/database"
+	"github.com/nnaumenko/wx/internal/wxupdate"
+)
+
+const (
+	redisServer = ":6379"
+
+	redisMaxIdleConnections   = 5  // Max idle Redis connections in the pool
+	redisMaxActiveConnections = 10 // Max active Redis connections in the pool
+
+	redisConnectTimeout = 5 * time.Second // Timeout of dialing Redis connection
+	redisReadTimeout    = 5 * time.Second // Timeout of reading Redis command reply
+	redisWriteTimeout   = 5 * time.Second // Timeout of writing Redis command
+)
+
+const usage = `Usage: wx-ctl [-sqlite <file> | -postgres <dsn>] <command> [options]
+
+Options:
+  -sqlite <file>     SQLite database file to use instead of Redis
+  -postgres <dsn>    PostgreSQL connection string to use instead of Redis
+
+Commands:
+  check    check stored data integrity and print the report
+  repair   repair METARs and TAFs for locations missing in the database;
+           without options only prints the number of orphaned reports
+           -placeholders    create placeholder locations for them
+           -delete          delete them
+  export   export all locations with current METARs and TAFs as a snapshot
+           which can be imported with wx-update -snapshot
+           -o <file>        write to file instead of standard output
+           -ndjson          write newline-delimited JSON instead of JSON array
+`
+
+func main() {
+	flags := flag.NewFlagSet("wx-ctl", flag.ExitOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	sqlite := flags.String("sqlite", "", "SQLite database file to use instead of Redis")
+	postgres := flags.String("postgres", "", "PostgreSQL connection string to use instead of Redis")
+	flags.Parse(os.Args[1:])
+	args := flags.Args()
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var db database.Database
+	switch {
+	case len(*sqlite) > 0:
+		var err error
+		if db, err = database.NewDbAccessSQLite(*sqlite); err != nil {
+			log.Fatalf("Unable to open database: %s", err.Error())
+		}
+	case len(*postgres) > 0:
+		var err error
+		if db, err = database.NewDbAccessPostgres(*postgres); err != nil {
+			log.Fatalf("Unable to open database: %s", err.Error())
+		}
+	default:
+		pool := database.NewRedisPool(database.RedisPoolConfig{
+			Server:         redisServer,
+			MaxIdle:        redisMaxIdleConnections,
+			MaxActive:      redisMaxActiveConnections,
+			ConnectTimeout: redisConnectTimeout,
+			ReadTimeout:    redisReadTimeout,
+			WriteTimeout:   redisWriteTimeout,
+		})
+		db = database.NewDbAccessRedis(pool)
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "check":
+		report, err := db.CheckIntegrity()
+		if err != nil {
+			log.Fatalf("Unable to check integrity: %s", err.Error())
+		}
+		printJSON(report)
+	case "repair":
+		repair(db, args[1:])
+	case "export":
+		export(db, args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %s\n\n%s", args[0], usage)
+		os.Exit(2)
+	}
+}
+
+func repair(db database.Database, args []string) {
+	flags := flag.NewFlagSet("repair", flag.ExitOnError)
+	placeholders := flags.Bool("placeholders", false, "create placeholder locations")
+	deleteOrphaned := flags.Bool("delete", false, "delete orphaned reports")
+	flags.Parse(args)
+
+	switch {
+	case *placeholders && *deleteOrphaned:
+		log.Fatalf("Options -placeholders and -delete cannot be used together")
+	case *placeholders:
+		num, err := db.RepairOrphaned(database.RepairCreatePlaceholders)
+		if err != nil {
+			log.Fatalf("Unable to create placeholder locations: %s", err.Error())
+		}
+		fmt.Printf("Created %d placeholder locations\n", num)
+	case *deleteOrphaned:
+		num, err := db.RepairOrphaned(database.RepairDeleteOrphaned)
+		if err != nil {
+			log.Fatalf("Unable to delete orphaned reports: %s", err.Error())
+		}
+		fmt.Printf("Deleted %d orphaned reports\n", num)
+	default:
+		report, err := db.CheckIntegrity()
+		if err != nil {
+			log.Fatalf("Unable to check integrity: %s", err.Error())
+		}
+		fmt.Printf("Found %d orphaned METARs and %d orphaned TAFs\n",
+			report.OrphanedMetars, report.OrphanedTafs)
+		fmt.Printf("Use -placeholders or -delete option to repair them\n")
+	}
+}
+
+func export(db database.Database, args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	output := flags.String("o", "", "output file")
+	ndjson := flags.Bool("ndjson", false, "write newline-delimited JSON")
+	flags.Parse(args)
+
+	format := wxupdate.SnapshotJSON
+	if *ndjson {
+		format = wxupdate.SnapshotNDJSON
+	}
+	w := os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatalf("Unable to create %s: %s", *output, err.Error())
+		}
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	ctx := wxupdate.UpdateContext{Db: db}
+	// Progress is logged to standard error, so it does not mix with the
+	// snapshot written to standard output
+	_, err := wxupdate.ExportSnapshot(&ctx, bw, format)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil && w != os.Stdout {
+		err = w.Close()
+	}
+	if err != nil {
+		log.Fatalf("Unable to export snapshot: %s", err.Error())
+	}
+}
+
+func printJSON(v interface{}) {
+	j, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Fatalf("Error converting to JSON: %s", err.Error())
+	}
+	fmt.Printf("%s\n", j)
+}
@@ -9,15 +9,15 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"time"
 
-	"github.com/gomodule/redigo/redis"
-
 	"github.com/nnaumenko/wx/internal/database"
+	"github.com/nnaumenko/wx/internal/util"
 	"github.com/nnaumenko/wx/internal/wxserver"
 )
 
@@ -31,32 +31,93 @@ const (
 	serverWriteTimeoutProfiling = 180 * time.Second
 )
 
+// Networks of reverse proxies allowed to pass client IP in X-Forwarded-For
+var trustedProxies = []string{"127.0.0.1/32", "::1/128"}
+
 const (
 	redisServer = ":6379"
 
 	redisMaxIdleConnections   = 50    // Max idle Redis connections in the pool
 	redisMaxActiveConnections = 10000 // Max active Redis connections in the pool
+	redisWarmupConnections    = 10    // Redis connections to dial at startup
+
+	redisConnectTimeout = 5 * time.Second // Timeout of dialing Redis connection
+	redisReadTimeout    = 5 * time.Second // Timeout of reading Redis command reply
+	redisWriteTimeout   = 5 * time.Second // Timeout of writing Redis command
 )
 
 func main() {
-	pool := redis.Pool{
-		MaxIdle:   redisMaxIdleConnections,
-		MaxActive: redisMaxActiveConnections,
-		Dial: func() (redis.Conn, error) {
-			c, err := redis.Dial("tcp", redisServer)
-			if err != nil {
-				log.Fatalf("Unable to create Redis connection pool: %s", err.Error())
-			}
-			return c, err
-		},
+	sqlite := flag.String("sqlite", "", "SQLite database file to use instead of Redis")
+	postgres := flag.String("postgres", "", "PostgreSQL connection string to use instead of Redis")
+	maxLocations := flag.Int("max-locations", 0,
+		"Maximum number of locations in URL query, 16 if zero")
+	maxFullLocations := flag.Int("max-full-locations", 0,
+		"Maximum number of locations in URL query of full endpoint, 4 if zero")
+	corsOrigins := flag.String("cors-origins", "",
+		"Comma-separated list of origins allowed to make CORS requests (default any origin)")
+	flagExpiredTafs := flag.Bool("flag-expired-tafs", false,
+		"Serve TAFs after the end of validity period with taf_expired field instead of omitting them")
+	tafAgeBasis := flag.String("taf-age-basis", "validity",
+		"Time to compute TAF age and freshness from, validity period or issue time (validity or issue)")
+	maxTafIssueAge := flag.Duration("max-taf-issue-age", 0,
+		"Age after which TAF expires if TAF age is computed from issue time, 6h if zero")
+	metricsInterval := flag.Duration("metrics-interval", 0,
+		"How often to recompute the gauges served by metrics endpoint, 5m if zero")
+	poolStatsInterval := flag.Duration("redis-pool-stats-interval", 5*time.Minute,
+		"How often to log Redis connection pool stats, disabled if zero")
+	flag.Parse()
+
+	// Standard logger may be replaced with structured logger implementing
+	// util.Logger
+	logger := util.StdLogger{}
+	database.SetLogger(logger)
+
+	var db database.Database
+	switch {
+	case len(*sqlite) > 0:
+		var err error
+		if db, err = database.NewDbAccessSQLite(*sqlite); err != nil {
+			log.Fatalf("Unable to open database: %s", err.Error())
+		}
+	case len(*postgres) > 0:
+		var err error
+		if db, err = database.NewDbAccessPostgres(*postgres); err != nil {
+			log.Fatalf("Unable to open database: %s", err.Error())
+		}
+	default:
+		db = newRedisDatabase(logger, *poolStatsInterval)
+	}
+	if err := db.Ping(); err != nil {
+		log.Fatalf("Database is not reachable: %s", err.Error())
 	}
-	database := database.NewDbAccessRedis(&pool)
 
-	//	logger := log.New(os.Stdout, "wx: ", log.LstdFlags)
+	proxies, err := util.ParseCIDRs(trustedProxies)
+	if err != nil {
+		log.Fatalf("Invalid trusted proxies: %s", err.Error())
+	}
 
 	ctx := wxserver.HandlerContext{
-		Db: database,
-		//		Log: *logger,
+		Db:               db,
+		TrustedProxies:   proxies,
+		MaxLocations:     *maxLocations,
+		MaxFullLocations: *maxFullLocations,
+		MetricsInterval:  *metricsInterval,
+		Log:              logger,
+	}
+	if *flagExpiredTafs {
+		ctx.ExpiredTafs = wxserver.ExpiredTafFlag
+	}
+	switch *tafAgeBasis {
+	case "validity":
+		ctx.TafAge = wxserver.TafAgeValidity
+	case "issue":
+		ctx.TafAge = wxserver.TafAgeIssue
+	default:
+		log.Fatalf("Invalid TAF age basis %s, must be validity or issue", *tafAgeBasis)
+	}
+	ctx.MaxTafIssueAge = *maxTafIssueAge
+	if len(*corsOrigins) > 0 {
+		ctx.AllowedOrigins = util.ParseURLQueryList([]string{*corsOrigins})
 	}
 
 	mux := http.NewServeMux()
@@ -102,5 +163,33 @@ func main() {
 		log.Fatalf("Unable to start server: %s\n", err.Error())
 	}
 	<-done
+	if err := db.Close(); err != nil {
+		log.Printf("Unable to close database: %s", err.Error())
+	}
 	log.Println("Server shutdown")
 }
+
+func newRedisDatabase(logger util.Logger, statsInterval time.Duration) database.Database {
+	pool := database.NewRedisPool(database.RedisPoolConfig{
+		Server:         redisServer,
+		MaxIdle:        redisMaxIdleConnections,
+		MaxActive:      redisMaxActiveConnections,
+		ConnectTimeout: redisConnectTimeout,
+		ReadTimeout:    redisReadTimeout,
+		WriteTimeout:   redisWriteTimeout,
+	})
+	if err := database.WarmupRedisPool(pool, redisWarmupConnections); err != nil {
+		logger.Warn("Redis connection pool warmup failed: %s", err.Error())
+	} else {
+		logger.Info("Redis connection pool warmed up with %d connections", redisWarmupConnections)
+	}
+	if statsInterval > 0 {
+		util.Schedule(
+			func() {
+				s := pool.Stats()
+				logger.Info("Redis connection pool: %d active, %d idle, %d in use",
+					s.ActiveCount, s.IdleCount, s.ActiveCount-s.IdleCount)
+			}, statsInterval)
+	}
+	return database.NewDbAccessRedis(pool)
+}
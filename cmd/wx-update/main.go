@@ -8,59 +8,163 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/gomodule/redigo/redis"
 	"github.com/nnaumenko/wx/internal/database"
 	"github.com/nnaumenko/wx/internal/util"
 	"github.com/nnaumenko/wx/internal/wxupdate"
 )
 
+const (
+	// Warn if number of updated reports drops below this fraction of the
+	// previous update
+	coverageWarnFraction = 0.5
+)
+
+const (
+	defaultLocationsInterval = 24 * time.Hour
+	defaultMetarsInterval    = 1 * time.Minute
+	defaultTafsInterval      = 1 * time.Minute
+)
+
 const (
 	redisServer = ":6379"
 
 	redisMaxIdleConnections   = 50    // Max idle Redis connections in the pool
 	redisMaxActiveConnections = 10000 // Max active Redis connections in the pool
+	redisWarmupConnections    = 10    // Redis connections to dial at startup
+
+	redisConnectTimeout = 5 * time.Second // Timeout of dialing Redis connection
+	redisReadTimeout    = 5 * time.Second // Timeout of reading Redis command reply
+	redisWriteTimeout   = 5 * time.Second // Timeout of writing Redis command
 )
 
 func main() {
-	pool := redis.Pool{
-		MaxIdle:   redisMaxIdleConnections,
-		MaxActive: redisMaxActiveConnections,
-		Dial: func() (redis.Conn, error) {
-			c, err := redis.Dial("tcp", redisServer)
-			if err != nil {
-				log.Fatalf("Unable to create Redis connection pool: %s", err.Error())
-			}
-			return c, err
-		},
+	snapshot := flag.String("snapshot", "",
+		"JSON or NDJSON snapshot file or URL to import before starting updates")
+	stations := flag.String("stations", "",
+		"Comma-separated list of stations to store METARs and TAFs for (default all)")
+	sqlite := flag.String("sqlite", "", "SQLite database file to use instead of Redis")
+	postgres := flag.String("postgres", "", "PostgreSQL connection string to use instead of Redis")
+	metarURL := flag.String("metar-url", "", "METAR CSV feed URL (default aviationweather.gov)")
+	tafURL := flag.String("taf-url", "", "TAF CSV feed URL (default aviationweather.gov)")
+	airportsURL := flag.String("airports-url", "", "OurAirports airports CSV URL (default ourairports.com)")
+	countriesURL := flag.String("countries-url", "", "OurAirports countries CSV URL (default ourairports.com)")
+	regionsURL := flag.String("regions-url", "", "OurAirports regions CSV URL (default ourairports.com)")
+	locationsInterval := flag.Duration("locations-interval", defaultLocationsInterval,
+		"Interval between location data updates")
+	metarsInterval := flag.Duration("metar-interval", defaultMetarsInterval, "Interval between METAR updates")
+	tafsInterval := flag.Duration("taf-interval", defaultTafsInterval, "Interval between TAF updates")
+	jitter := flag.Duration("jitter", 0,
+		"Maximum random delay of the first update of each kind, to avoid simultaneous updates")
+	flag.Parse()
+	if *locationsInterval <= 0 || *metarsInterval <= 0 || *tafsInterval <= 0 {
+		log.Fatalf("Update intervals must be positive")
 	}
-	database := database.NewDbAccessRedis(&pool)
-	//	logger := log.New(os.Stdout, "wx: ", log.LstdFlags)
+
+	// Standard logger may be replaced with structured logger implementing
+	// util.Logger
+	logger := util.StdLogger{}
+	database.SetLogger(logger)
+
+	var db database.Database
+	switch {
+	case len(*sqlite) > 0:
+		var err error
+		if db, err = database.NewDbAccessSQLite(*sqlite); err != nil {
+			log.Fatalf("Unable to open database: %s", err.Error())
+		}
+	case len(*postgres) > 0:
+		var err error
+		if db, err = database.NewDbAccessPostgres(*postgres); err != nil {
+			log.Fatalf("Unable to open database: %s", err.Error())
+		}
+	default:
+		db = newRedisDatabase()
+	}
+	// Cancelled on interrupt or termination (sent on deploys) to stop
+	// scheduled updates
+	ctx, cancel := context.WithCancel(context.Background())
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-quit
+		log.Println("Shutting down")
+		cancel()
+	}()
 
 	context := wxupdate.UpdateContext{
-		Db:                database,
+		Db:                db,
 		MetarsLastUpdated: time.Unix(0, 0),
 		TafsLastUpdated:   time.Unix(0, 0),
-		//		Log: *logger,
+
+		CoverageWarnFraction: coverageWarnFraction,
+		Log:                  logger,
+
+		MetarURL:                *metarURL,
+		TafURL:                  *tafURL,
+		OurAirportsAirportsURL:  *airportsURL,
+		OurAirportsCountriesURL: *countriesURL,
+		OurAirportsRegionsURL:   *regionsURL,
+	}
+	if err := wxupdate.ValidateURLs(&context); err != nil {
+		log.Fatalf("%s", err.Error())
+	}
+
+	if len(*stations) > 0 {
+		context.IngestOnlyStations = util.ParseURLQueryList([]string{*stations})
+	}
+
+	if len(*snapshot) > 0 {
+		wxupdate.ImportSnapshot(&context, *snapshot)
 	}
 
-	util.Schedule(
+	doneLocations := util.ScheduleWithJitterContext(ctx,
 		func() {
 			wxupdate.GetFromOurAirports(&context)
-		}, 24*time.Hour)
+		}, *locationsInterval, *jitter)
 
-	util.Schedule(
+	doneMetars := util.ScheduleWithJitterContext(ctx,
 		func() {
 			wxupdate.UpdateMetars(&context)
-		}, 1*time.Minute)
+		}, *metarsInterval, *jitter)
 
-	util.Schedule(
+	doneTafs := util.ScheduleWithJitterContext(ctx,
 		func() {
 			wxupdate.UpdateTafs(&context)
-		}, 1*time.Minute)
-	for {
-		select {}
+		}, *tafsInterval, *jitter)
+
+	// Updates in progress are completed before exit, including storing
+	// the METARs and TAFs collected from the CSV with SetMETARs and
+	// SetTAFs, so no reports read during the current cycle are lost
+	<-doneLocations
+	<-doneMetars
+	<-doneTafs
+	if err := db.Close(); err != nil {
+		log.Printf("Unable to close database: %s", err.Error())
+	}
+	log.Println("Updater shutdown")
+}
+
+func newRedisDatabase() database.Database {
+	pool := database.NewRedisPool(database.RedisPoolConfig{
+		Server:         redisServer,
+		MaxIdle:        redisMaxIdleConnections,
+		MaxActive:      redisMaxActiveConnections,
+		ConnectTimeout: redisConnectTimeout,
+		ReadTimeout:    redisReadTimeout,
+		WriteTimeout:   redisWriteTimeout,
+	})
+	if err := database.WarmupRedisPool(pool, redisWarmupConnections); err != nil {
+		log.Printf("Redis connection pool warmup failed: %s", err.Error())
+	} else {
+		log.Printf("Redis connection pool warmed up with %d connections", redisWarmupConnections)
 	}
+	return database.NewDbAccessRedis(pool)
 }
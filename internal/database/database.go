@@ -8,26 +8,27 @@
 package database
 
 import (
+	"context"
 	"fmt"
+	"math"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gomodule/redigo/redis"
+
+	"github.com/nnaumenko/wx/internal/util"
+	"github.com/nnaumenko/wx/pkg/wxtypes"
 )
 
-// DataICAOLocation is the data retreived from database for a single location
-// designated by an ICAO location code.
-// Has JSON tags to be marshalled easily.
-type DataICAOLocation struct {
-	Location       string  `json:"location,omitempty"`
-	Metar          string  `json:"metar,omitempty"`
-	Taf            string  `json:"taf,omitempty"`
-	Name           string  `json:"name,omitempty"`
-	City           string  `json:"city,omitempty"`
-	CountryCode    string  `json:"country_code,omitempty"`
-	Latitude       float64 `json:"latitude,omitempty"`
-	Longitude      float64 `json:"longitude,omitempty"`
-	AltitudeMeters int     `json:"altitude_meters,omitempty"`
-	AltitudeFeet   int     `json:"altitude_feet,omitempty"`
+// logger receives the messages of all Database implementations
+var logger util.Logger = util.StdLogger{}
+
+// SetLogger sets the logger used by all Database implementations; StdLogger
+// is used if l is nil.
+func SetLogger(l util.Logger) {
+	logger = util.LoggerOrDefault(l)
 }
 
 // Database interface is an abstraction for database which stores the weather
@@ -38,50 +39,290 @@ type Database interface {
 	// Does not validate ICAO locations passed in loc argument.
 	// Does not limit number of locations.
 	// Locations not found in the database are not included in the slice.
-	// All fields of DataICAOLocation are intialised.
-	GetICAOLocationData(loc []string) ([]*DataICAOLocation, error)
+	// Other locations are in the same order as in loc argument.
+	// Locations with corrupt data in the database are logged and not
+	// included in the slice.
+	// All fields of DataICAOLocation are intialised, except for
+	// MetarObservationTime, TafValidFrom, TafValidTo and TafIssueTime if
+	// they are not known.
+	GetICAOLocationData(loc []string) ([]*wxtypes.DataICAOLocation, error)
+
+	// GetLocationInfo retreives only location data for one or more ICAO
+	// locations, without METAR and TAF reports.
+	// Does not validate ICAO locations passed in loc argument.
+	// Does not limit number of locations.
+	// Locations not found in the database are not included in the slice.
+	// Other locations are in the same order as in loc argument.
+	// Locations with corrupt data in the database are logged and not
+	// included in the slice.
+	// All fields of DataICAOLocation except Metar and Taf are intialised.
+	GetLocationInfo(loc []string) ([]*wxtypes.DataICAOLocation, error)
 
 	// GetMETARs retreives only METAR reports for one or more ICAO locations.
 	// Does not validate ICAO locations passed in loc argument.
 	// Does not limit number of locations.
 	// Locations not found in the database are not included in the slice.
-	// Only Location and Metar fields are initialised in DataICAOLocation.
-	GetMETARs(loc []string) ([]*DataICAOLocation, error)
+	// Other locations are in the same order as in loc argument.
+	// Only Location, Metar and MetarObservationTime (if known) fields are
+	// initialised in DataICAOLocation.
+	GetMETARs(loc []string) ([]*wxtypes.DataICAOLocation, error)
 
 	// GetTAFs retreives only METAR reports for one or more ICAO locations.
 	// Does not validate ICAO locations passed in loc argument.
 	// Does not limit number of locations.
 	// Locations not found in the database are not included in the slice.
-	// Only Location and Taf fields are initialised in DataICAOLocation.
-	GetTAFs(loc []string) ([]*DataICAOLocation, error)
+	// Other locations are in the same order as in loc argument.
+	// Only Location, Taf, TafValidFrom, TafValidTo and TafIssueTime (if
+	// known) fields are initialised in DataICAOLocation.
+	GetTAFs(loc []string) ([]*wxtypes.DataICAOLocation, error)
 
 	// GetMETARsTAFs retreives only METAR and TAF reports for ICAO locations.
 	// Does not validate ICAO locations passed in loc argument.
 	// Does not limit number of locations.
 	// Locations not found in the database are not included in the slice.
-	// Only Location, Metar and Taf fields are initialised in DataICAOLocation.
-	GetMETARsTAFs(loc []string) ([]*DataICAOLocation, error)
+	// Other locations are in the same order as in loc argument.
+	// Only Location, Metar, MetarObservationTime (if known), Taf,
+	// TafValidFrom, TafValidTo and TafIssueTime (if known) fields are
+	// initialised in DataICAOLocation.
+	GetMETARsTAFs(loc []string) ([]*wxtypes.DataICAOLocation, error)
+
+	// GetICAOLocationDataContext, GetLocationInfoContext, GetMETARsContext,
+	// GetTAFsContext and GetMETARsTAFsContext are the same as methods above
+	// without Context suffix, but stop waiting for the database and return
+	// the context error when ctx is cancelled or its deadline is exceeded.
+	GetICAOLocationDataContext(ctx context.Context, loc []string) ([]*wxtypes.DataICAOLocation, error)
+	GetLocationInfoContext(ctx context.Context, loc []string) ([]*wxtypes.DataICAOLocation, error)
+	GetMETARsContext(ctx context.Context, loc []string) ([]*wxtypes.DataICAOLocation, error)
+	GetTAFsContext(ctx context.Context, loc []string) ([]*wxtypes.DataICAOLocation, error)
+	GetMETARsTAFsContext(ctx context.Context, loc []string) ([]*wxtypes.DataICAOLocation, error)
+
+	// GetMETARTTL retreives remaining time-to-expire of METAR for an ICAO
+	// location in seconds.
+	// Returns -1 if METAR does not expire and -2 if there is no METAR for
+	// the location.
+	// Does not validate ICAO location.
+	GetMETARTTL(loc string) (int64, error)
+
+	// GetTAFTTL retreives remaining time-to-expire of TAF for an ICAO
+	// location in seconds.
+	// Returns -1 if TAF does not expire and -2 if there is no TAF for the
+	// location.
+	// Does not validate ICAO location.
+	GetTAFTTL(loc string) (int64, error)
 
 	// LocationExists checks whether an ICAO location exists in the database.
 	// Does not validate ICAO location.
 	LocationExists(loc string) (bool, error)
 
-	// SetDataICAOLocation sets the location data in the database.
-	// Only Location, Name, City, CountryCode, Latitude, Longitude,
-	// AltitudeFeet fields are saved from DataICAOLocation to database.
-	SetDataICAOLocation(data *DataICAOLocation) error
+	// SetDataICAOLocation sets the location data in the database,
+	// overwriting the existing data for the location.
+	// Only Location, Name, City, CountryCode, CountryName, Region, Latitude,
+	// Longitude, AltitudeFeet, Timezone, Closed fields are saved from DataICAOLocation
+	// to database.
+	SetDataICAOLocation(data *wxtypes.DataICAOLocation) error
+
+	// SetDataICAOLocationIfAbsent is the same as SetDataICAOLocation but
+	// does nothing if the location already exists in the database.
+	SetDataICAOLocationIfAbsent(data *wxtypes.DataICAOLocation) error
+
+	// UpdateLocationField updates a single field of location data in the
+	// database, without rewriting other fields.
+	// Field is one of LocationField constants. Numeric fields are validated.
+	// Returns an error for unknown field names or if the location does not
+	// exist in the database.
+	// Does not validate ICAO location.
+	UpdateLocationField(loc string, field string, value string) error
 
 	// SetMETAR sets or updates single METAR for an ICAO location.
 	// Does not validate ICAO location.
+	// ObsTime is the time when METAR observation was taken; zero time means
+	// the time is not known.
 	// Expire is the time-to-expire for the METAR in seconds.
-	SetMETAR(loc string, metar string, expire int64) error
+	SetMETAR(loc string, metar string, obsTime time.Time, expire int64) error
 
 	// SetTAF sets or updates single TAF for an ICAO location.
 	// Does not validate ICAO location.
+	// ValidFrom and ValidTo are the start and the end of TAF validity
+	// period; zero time means the time is not known.
+	// Issue time is not known; SetTAFs sets TAF along with issue time.
 	// Expire is the time-to-expire for the METAR in seconds.
-	SetTAF(loc string, taf string, expire int64) error
+	SetTAF(loc string, taf string, validFrom time.Time, validTo time.Time, expire int64) error
+
+	// SetMETARs sets or updates multiple METARs in a single operation.
+	// All entries are processed even if some of them fail; the first
+	// error is returned.
+	// Does not validate ICAO locations.
+	SetMETARs(entries []MetarEntry) error
+
+	// SetTAFs sets or updates multiple TAFs in a single operation.
+	// All entries are processed even if some of them fail; the first
+	// error is returned.
+	// Does not validate ICAO locations.
+	SetTAFs(entries []TafEntry) error
+
+	// DeleteMETAR deletes METAR for an ICAO location before it expires.
+	// Does not return an error if there is no METAR for the location.
+	// Does not validate ICAO location.
+	DeleteMETAR(loc string) error
+
+	// DeleteTAF deletes TAF for an ICAO location before it expires.
+	// Does not return an error if there is no TAF for the location.
+	// Does not validate ICAO location.
+	DeleteTAF(loc string) error
+
+	// CheckIntegrity scans the database for inconsistent data, such as
+	// locations with unparseable fields or METARs and TAFs for locations
+	// which are not in the database. Does not modify the data.
+	CheckIntegrity() (IntegrityReport, error)
+
+	// RepairOrphaned repairs METARs and TAFs for locations which are not in
+	// the database, as reported by CheckIntegrity, either by creating
+	// placeholder location data with only Location field set or by
+	// deleting the orphaned reports. Returns number of repaired locations
+	// (RepairCreatePlaceholders) or reports (RepairDeleteOrphaned).
+	RepairOrphaned(action RepairAction) (int, error)
+
+	// ListLocations retreives a page of ICAO locations which begin with
+	// prefix (all locations if prefix is empty). Cursor is zero for the
+	// first page or the value returned by the previous call for the
+	// following pages; the returned cursor is zero after the last page.
+	// Count is a hint of the page size; the page may contain a different
+	// number of locations, including none.
+	// The order of locations is not guaranteed, neither within a page nor
+	// across calls.
+	ListLocations(prefix string, cursor uint64, count int) ([]string, uint64, error)
+
+	// GetNearestLocations retreives location data for up to n locations
+	// nearest to the point specified by latitude and longitude, ordered by
+	// distance ascending.
+	// Locations at latitudes beyond GeoMaxLatitude are not included.
+	// All fields of DataICAOLocation except Metar and Taf are intialised,
+	// including DistanceKm.
+	GetNearestLocations(lat float64, lon float64, n int) ([]*wxtypes.DataICAOLocation, error)
+
+	// GetLocationsInBox retreives location data for the locations within
+	// the bounding box specified by minimum and maximum latitude and
+	// longitude, ordered by ICAO location code.
+	// Locations at latitudes beyond GeoMaxLatitude are not included.
+	// All fields of DataICAOLocation except Metar, Taf and DistanceKm are
+	// initialised.
+	GetLocationsInBox(minLat float64, minLon float64, maxLat float64, maxLon float64) ([]*wxtypes.DataICAOLocation, error)
+
+	// Ping checks whether the database is reachable.
+	Ping() error
+
+	// GetDataEpoch retreives data epoch, a number which increases every
+	// time the location data are fully re-imported. Clients may use it to
+	// detect changes of the dataset. Returns zero if location data were
+	// never imported.
+	GetDataEpoch() (int64, error)
+
+	// IncrementDataEpoch increments data epoch and returns the new value.
+	IncrementDataEpoch() (int64, error)
+
+	// GetCounts retreives the number of locations, METARs and TAFs stored
+	// in the database. Expired reports are not counted.
+	GetCounts() (locations int, metars int, tafs int, err error)
+
+	// GetCountryCounts retreives the number of locations and the number of
+	// locations with current METAR for each country code. Locations without
+	// country code are counted under empty country code.
+	GetCountryCounts() (map[string]CountryCount, error)
+
+	// Close stops background maintenance, if any, waiting for the one in
+	// progress, and releases the connections. The database must not be
+	// used after Close.
+	Close() error
+}
+
+// CountryCount is the number of locations in a country retreived by
+// GetCountryCounts.
+type CountryCount struct {
+	// Locations is the number of locations in the country
+	Locations int
+	// Reporting is the number of locations with current METAR
+	Reporting int
+}
+
+// MetarEntry is a single METAR set by SetMETARs. Fields are the same as
+// SetMETAR arguments.
+type MetarEntry struct {
+	Location string
+	Metar    string
+	ObsTime  time.Time
+	Expire   int64
+}
+
+// TafEntry is a single TAF set by SetTAFs. Fields are the same as SetTAF
+// arguments, except IssueTime which is the time when TAF was issued; zero
+// time means the time is not known.
+type TafEntry struct {
+	Location  string
+	Taf       string
+	ValidFrom time.Time
+	ValidTo   time.Time
+	IssueTime time.Time
+	Expire    int64
+}
+
+// timeOrEmpty formats t in RFC3339 format, or returns empty string if t is
+// zero time.
+func timeOrEmpty(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
 }
 
+// RepairAction specifies how RepairOrphaned repairs orphaned reports.
+type RepairAction int
+
+const (
+	// RepairCreatePlaceholders creates placeholder location data
+	RepairCreatePlaceholders RepairAction = iota
+	// RepairDeleteOrphaned deletes orphaned METARs and TAFs
+	RepairDeleteOrphaned
+)
+
+// IntegrityReport contains the results of database integrity check. Sample
+// fields hold up to IntegrityReportMaxSamples ICAO locations for each kind
+// of anomaly.
+// Has JSON tags to be marshalled easily.
+type IntegrityReport struct {
+	Locations              int      `json:"locations"`
+	CorruptLocations       int      `json:"corrupt_locations"`
+	CorruptLocationSamples []string `json:"corrupt_location_samples,omitempty"`
+	Metars                 int      `json:"metars"`
+	OrphanedMetars         int      `json:"orphaned_metars"`
+	OrphanedMetarSamples   []string `json:"orphaned_metar_samples,omitempty"`
+	Tafs                   int      `json:"tafs"`
+	OrphanedTafs           int      `json:"orphaned_tafs"`
+	OrphanedTafSamples     []string `json:"orphaned_taf_samples,omitempty"`
+}
+
+// GeoMaxLatitude is the maximum absolute latitude of locations found by
+// GetNearestLocations. It is the limit of Redis GEO commands.
+const GeoMaxLatitude = 85.05112878
+
+// IntegrityReportMaxSamples is the maximum number of samples of each kind of
+// anomaly in IntegrityReport.
+const IntegrityReportMaxSamples = 10
+
+// Location fields which can be updated with UpdateLocationField. The names
+// are the same as JSON field names of DataICAOLocation.
+const (
+	LocationFieldName         = "name"
+	LocationFieldCity         = "city"
+	LocationFieldCountryCode  = "country_code"
+	LocationFieldCountryName  = "country_name"
+	LocationFieldRegion       = "region"
+	LocationFieldLatitude     = "latitude"
+	LocationFieldLongitude    = "longitude"
+	LocationFieldAltitudeFeet = "altitude_feet"
+	LocationFieldTimezone     = "timezone"
+	LocationFieldClosed       = "closed"
+)
+
 ////////////////////////////////////////////////////////////////////////////////
 
 // DbRedis is an implementation of retreival data stored in Redis
@@ -94,42 +335,126 @@ const (
 	dbRedisICAOPrefixMetar    = "wx:icao:metar:"
 	dbRedisICAOPrefixTaf      = "wx:icao:taf:"
 
+	// METAR observation time is stored separately from METAR with the same
+	// expire time
+	dbRedisICAOPrefixMetarTime = "wx:icao:metartime:"
+	// TAF validity period and issue time are stored separately from TAF in
+	// a hash with the same expire time
+	dbRedisICAOPrefixTafValid = "wx:icao:tafvalid:"
+
+	dbRedisTafValidFieldFrom   = "from"
+	dbRedisTafValidFieldTo     = "to"
+	dbRedisTafValidFieldIssued = "issued"
+
+	dbRedisKeyDataEpoch = "wx:epoch"
+	dbRedisKeyGeo       = "wx:icao:geo"
+
 	dbRedisICAOLocFieldName         = "name"
 	dbRedisICAOLocFieldCity         = "city"
 	dbRedisICAOLocFieldCountryCode  = "country"
+	dbRedisICAOLocFieldCountryName  = "country_name"
+	dbRedisICAOLocFieldRegion       = "region"
 	dbRedisICAOLocFieldLatitude     = "lat"
 	dbRedisICAOLocFieldLongitude    = "lon"
 	dbRedisICAOLocFieldAltitudeFeet = "alt_ft"
+	dbRedisICAOLocFieldTimezone     = "tz"
+	dbRedisICAOLocFieldClosed       = "closed"
 )
 
 // GetICAOLocationData retreives selected data fields for ICAO locations.
 // See Database interface for details.
-func (db *DbRedis) GetICAOLocationData(loc []string) ([]*DataICAOLocation, error) {
-	metars, err := db.getMetarStrs(loc)
+func (db *DbRedis) GetICAOLocationData(loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	return db.GetICAOLocationDataContext(context.Background(), loc)
+}
+
+// GetICAOLocationDataContext retreives selected data fields for ICAO
+// locations.
+// See Database interface for details.
+func (db *DbRedis) GetICAOLocationDataContext(ctx context.Context, loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	conn, err := db.getContext(ctx)
 	if err != nil {
-		return make([]*DataICAOLocation, 0), err
+		return make([]*wxtypes.DataICAOLocation, 0), err
 	}
-	tafs, err := db.getTafStrs(loc)
+	defer conn.Close()
+
+	// Pipeline all commands to retreive the data in a single round trip
+	conn.Send("MGET", prefixedKeys(dbRedisICAOPrefixMetar, loc)...)
+	conn.Send("MGET", prefixedKeys(dbRedisICAOPrefixMetarTime, loc)...)
+	conn.Send("MGET", prefixedKeys(dbRedisICAOPrefixTaf, loc)...)
+	sendTafValidity(conn, loc)
+	sendLocationStrMaps(conn, loc)
+	if err := conn.Flush(); err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	metars, err := redis.Strings(receiveContext(ctx, conn))
 	if err != nil {
-		return make([]*DataICAOLocation, 0), err
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	metarTimes, err := redis.Strings(receiveContext(ctx, conn))
+	if err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	tafs, err := redis.Strings(receiveContext(ctx, conn))
+	if err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	tafValid, err := receiveTafValidity(ctx, conn, len(loc))
+	if err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	locs, err := receiveLocationStrMaps(ctx, conn, len(loc))
+	if err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
 	}
 
-	var result []*DataICAOLocation
-	conn := db.pool.Get()
-	defer conn.Close()
-
-	for i, l := range loc {
-		v, err := redis.StringMap(conn.Do("HGETALL", dbRedisICAOPrefixLocation+l))
-		if err != nil {
-			return make([]*DataICAOLocation, 0), err
-		}
+	var result []*wxtypes.DataICAOLocation
+	for i, v := range locs {
 		if len(v) > 0 {
-			ld, err := db.makeLocationData(l, v)
+			ld, err := db.makeLocationData(loc[i], v)
 			if err != nil {
-				return make([]*DataICAOLocation, 0), err
+				// Skip the corrupt location rather than failing all of them
+				logger.Warn("%sSkipping location %s with invalid data: %s",
+					util.RequestIDPrefix(ctx), loc[i], err.Error())
+				continue
 			}
 			ld.Metar = metars[i]
+			if len(ld.Metar) > 0 {
+				ld.MetarObservationTime = metarTimes[i]
+			}
 			ld.Taf = tafs[i]
+			if len(ld.Taf) > 0 {
+				tafValid[i].set(ld)
+			}
+			result = append(result, ld)
+		}
+	}
+	return result, nil
+}
+
+// GetLocationInfo retreives only location data for ICAO locations.
+// See Database interface for details.
+func (db *DbRedis) GetLocationInfo(loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	return db.GetLocationInfoContext(context.Background(), loc)
+}
+
+// GetLocationInfoContext retreives only location data for ICAO locations.
+// See Database interface for details.
+func (db *DbRedis) GetLocationInfoContext(ctx context.Context, loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	locs, err := db.getLocationStrMaps(ctx, loc)
+	if err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+
+	var result []*wxtypes.DataICAOLocation
+	for i, v := range locs {
+		if len(v) > 0 {
+			ld, err := db.makeLocationData(loc[i], v)
+			if err != nil {
+				// Skip the corrupt location rather than failing all of them
+				logger.Warn("%sSkipping location %s with invalid data: %s",
+					util.RequestIDPrefix(ctx), loc[i], err.Error())
+				continue
+			}
 			result = append(result, ld)
 		}
 	}
@@ -138,17 +463,24 @@ func (db *DbRedis) GetICAOLocationData(loc []string) ([]*DataICAOLocation, error
 
 // GetMETARs retreives only METAR reports for ICAO locations.
 // See Database interface for details.
-func (db *DbRedis) GetMETARs(loc []string) ([]*DataICAOLocation, error) {
-	var result []*DataICAOLocation
-	metars, err := db.getMetarStrs(loc)
+func (db *DbRedis) GetMETARs(loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	return db.GetMETARsContext(context.Background(), loc)
+}
+
+// GetMETARsContext retreives only METAR reports for ICAO locations.
+// See Database interface for details.
+func (db *DbRedis) GetMETARsContext(ctx context.Context, loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	var result []*wxtypes.DataICAOLocation
+	metars, metarTimes, err := db.getMetarStrs(ctx, loc)
 	if err != nil {
-		return make([]*DataICAOLocation, 0), err
+		return make([]*wxtypes.DataICAOLocation, 0), err
 	}
 	for i, metar := range metars {
 		if len(metar) > 0 {
-			var l DataICAOLocation
+			var l wxtypes.DataICAOLocation
 			l.Location = loc[i]
 			l.Metar = metar
+			l.MetarObservationTime = metarTimes[i]
 			result = append(result, &l)
 		}
 	}
@@ -157,17 +489,24 @@ func (db *DbRedis) GetMETARs(loc []string) ([]*DataICAOLocation, error) {
 
 // GetTAFs retreives only TAF reports for ICAO locations.
 // See Database interface for details.
-func (db *DbRedis) GetTAFs(loc []string) ([]*DataICAOLocation, error) {
-	var result []*DataICAOLocation
-	tafs, err := db.getTafStrs(loc)
+func (db *DbRedis) GetTAFs(loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	return db.GetTAFsContext(context.Background(), loc)
+}
+
+// GetTAFsContext retreives only TAF reports for ICAO locations.
+// See Database interface for details.
+func (db *DbRedis) GetTAFsContext(ctx context.Context, loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	var result []*wxtypes.DataICAOLocation
+	tafs, tafValid, err := db.getTafStrs(ctx, loc)
 	if err != nil {
-		return make([]*DataICAOLocation, 0), err
+		return make([]*wxtypes.DataICAOLocation, 0), err
 	}
 	for i, metar := range tafs {
 		if len(metar) > 0 {
-			var l DataICAOLocation
+			var l wxtypes.DataICAOLocation
 			l.Location = loc[i]
 			l.Taf = metar
+			tafValid[i].set(&l)
 			result = append(result, &l)
 		}
 	}
@@ -176,34 +515,62 @@ func (db *DbRedis) GetTAFs(loc []string) ([]*DataICAOLocation, error) {
 
 // GetMETARsTAFs retreives only METAR and TAF reports for ICAO locations.
 // See Database interface for details.
-func (db *DbRedis) GetMETARsTAFs(loc []string) ([]*DataICAOLocation, error) {
-	var result []*DataICAOLocation
-	conn := db.pool.Get()
-	defer conn.Close()
+func (db *DbRedis) GetMETARsTAFs(loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	return db.GetMETARsTAFsContext(context.Background(), loc)
+}
 
-	m, err := db.getMetarStrs(loc)
+// GetMETARsTAFsContext retreives only METAR and TAF reports for ICAO
+// locations.
+// See Database interface for details.
+func (db *DbRedis) GetMETARsTAFsContext(ctx context.Context, loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	var result []*wxtypes.DataICAOLocation
+	m, mt, err := db.getMetarStrs(ctx, loc)
 	if err != nil {
-		return make([]*DataICAOLocation, 0), err
+		return make([]*wxtypes.DataICAOLocation, 0), err
 	}
-	t, err := db.getTafStrs(loc)
+	t, tv, err := db.getTafStrs(ctx, loc)
 	if err != nil {
-		return make([]*DataICAOLocation, 0), err
+		return make([]*wxtypes.DataICAOLocation, 0), err
 	}
-	if len(m) != len(t) {
-		panic("GetMETARsTAFs: METARs vs TAFs length mismatch")
+	if len(m) != len(t) || len(m) != len(loc) || len(mt) != len(loc) || len(tv) != len(loc) {
+		return make([]*wxtypes.DataICAOLocation, 0),
+			fmt.Errorf("Inconsistent number of METARs %d and TAFs %d for %d locations",
+				len(m), len(t), len(loc))
 	}
 	for i := 0; i < len(m); i++ {
-		if len(m) > 0 {
-			var l DataICAOLocation
+		if len(m[i]) > 0 || len(t[i]) > 0 {
+			var l wxtypes.DataICAOLocation
 			l.Location = loc[i]
 			l.Metar = m[i]
+			if len(l.Metar) > 0 {
+				l.MetarObservationTime = mt[i]
+			}
 			l.Taf = t[i]
+			if len(l.Taf) > 0 {
+				tv[i].set(&l)
+			}
 			result = append(result, &l)
 		}
 	}
 	return result, nil
 }
 
+// GetMETARTTL retreives remaining time-to-expire of METAR for a location.
+// See Database interface for details.
+func (db *DbRedis) GetMETARTTL(loc string) (int64, error) {
+	conn := db.pool.Get()
+	defer conn.Close()
+	return redis.Int64(conn.Do("TTL", dbRedisICAOPrefixMetar+loc))
+}
+
+// GetTAFTTL retreives remaining time-to-expire of TAF for a location.
+// See Database interface for details.
+func (db *DbRedis) GetTAFTTL(loc string) (int64, error) {
+	conn := db.pool.Get()
+	defer conn.Close()
+	return redis.Int64(conn.Do("TTL", dbRedisICAOPrefixTaf+loc))
+}
+
 // LocationExists checks whether an ICAO location exists in the database.
 // See Database interface for details.
 func (db *DbRedis) LocationExists(loc string) (bool, error) {
@@ -215,7 +582,16 @@ func (db *DbRedis) LocationExists(loc string) (bool, error) {
 
 // SetDataICAOLocation sets the location data in the database.
 // See Database interface for details.
-func (db *DbRedis) SetDataICAOLocation(data *DataICAOLocation) error {
+func (db *DbRedis) SetDataICAOLocation(data *wxtypes.DataICAOLocation) error {
+	conn := db.pool.Get()
+	defer conn.Close()
+	return setLocation(conn, data)
+}
+
+// SetDataICAOLocationIfAbsent sets the location data in the database unless
+// the location already exists.
+// See Database interface for details.
+func (db *DbRedis) SetDataICAOLocationIfAbsent(data *wxtypes.DataICAOLocation) error {
 	conn := db.pool.Get()
 	defer conn.Close()
 	exists, err := redis.Bool(conn.Do("EXISTS", dbRedisICAOPrefixLocation+data.Location))
@@ -223,40 +599,436 @@ func (db *DbRedis) SetDataICAOLocation(data *DataICAOLocation) error {
 		return fmt.Errorf("EXISTS command returned error: %s", err.Error())
 	}
 	if !exists {
-		_, err := conn.Do("HSET",
-			dbRedisICAOPrefixLocation+data.Location,
-			dbRedisICAOLocFieldName, data.Name,
-			dbRedisICAOLocFieldCity, data.City,
-			dbRedisICAOLocFieldCountryCode, data.CountryCode,
-			dbRedisICAOLocFieldLatitude, data.Latitude,
-			dbRedisICAOLocFieldLongitude, data.Longitude,
-			dbRedisICAOLocFieldAltitudeFeet, data.AltitudeFeet,
-		)
-		return err
+		return setLocation(conn, data)
 	}
 	return nil
 }
 
+// setLocation saves the location data and adds the location to the
+// geospatial index unless it is beyond the latitudes supported by Redis.
+func setLocation(conn redis.Conn, data *wxtypes.DataICAOLocation) error {
+	conn.Send("MULTI")
+	conn.Send("HSET", locationHashArgs(data)...)
+	sendGeo(conn, data.Location, data.Latitude, data.Longitude)
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+// sendGeo queues the command which adds the location to the geospatial
+// index, or removes it from the index if the location is beyond the
+// latitudes supported by Redis.
+func sendGeo(conn redis.Conn, loc string, lat float64, lon float64) {
+	if math.Abs(lat) <= GeoMaxLatitude {
+		conn.Send("GEOADD", dbRedisKeyGeo, lon, lat, loc)
+	} else {
+		conn.Send("ZREM", dbRedisKeyGeo, loc)
+	}
+}
+
+// locationHashArgs returns HSET arguments to save the location data.
+func locationHashArgs(data *wxtypes.DataICAOLocation) []interface{} {
+	return []interface{}{
+		dbRedisICAOPrefixLocation + data.Location,
+		dbRedisICAOLocFieldName, data.Name,
+		dbRedisICAOLocFieldCity, data.City,
+		dbRedisICAOLocFieldCountryCode, data.CountryCode,
+		dbRedisICAOLocFieldCountryName, data.CountryName,
+		dbRedisICAOLocFieldRegion, data.Region,
+		dbRedisICAOLocFieldLatitude, data.Latitude,
+		dbRedisICAOLocFieldLongitude, data.Longitude,
+		dbRedisICAOLocFieldAltitudeFeet, data.AltitudeFeet,
+		dbRedisICAOLocFieldTimezone, data.Timezone,
+		dbRedisICAOLocFieldClosed, strconv.FormatBool(data.Closed),
+	}
+}
+
+// UpdateLocationField updates a single field of location data.
+// See Database interface for details.
+func (db *DbRedis) UpdateLocationField(loc string, field string, value string) error {
+	var dbField string
+	var err error
+	switch field {
+	case LocationFieldName:
+		dbField = dbRedisICAOLocFieldName
+	case LocationFieldCity:
+		dbField = dbRedisICAOLocFieldCity
+	case LocationFieldCountryCode:
+		dbField = dbRedisICAOLocFieldCountryCode
+	case LocationFieldCountryName:
+		dbField = dbRedisICAOLocFieldCountryName
+	case LocationFieldRegion:
+		dbField = dbRedisICAOLocFieldRegion
+	case LocationFieldLatitude:
+		dbField = dbRedisICAOLocFieldLatitude
+		_, err = strconv.ParseFloat(value, 64)
+	case LocationFieldLongitude:
+		dbField = dbRedisICAOLocFieldLongitude
+		_, err = strconv.ParseFloat(value, 64)
+	case LocationFieldAltitudeFeet:
+		dbField = dbRedisICAOLocFieldAltitudeFeet
+		_, err = strconv.Atoi(value)
+	case LocationFieldTimezone:
+		dbField = dbRedisICAOLocFieldTimezone
+	case LocationFieldClosed:
+		dbField = dbRedisICAOLocFieldClosed
+		_, err = strconv.ParseBool(value)
+	default:
+		return fmt.Errorf("Unknown location field %s", field)
+	}
+	if err != nil {
+		return fmt.Errorf("Invalid value %s for location field %s: %s", value, field, err.Error())
+	}
+
+	conn := db.pool.Get()
+	defer conn.Close()
+	for i := 0; i < updateLocationFieldAttempts; i++ {
+		done, err := updateLocationField(conn, loc, field, dbField, value)
+		if err != nil || done {
+			return err
+		}
+	}
+	return fmt.Errorf("Location %s was modified concurrently %d times", loc, updateLocationFieldAttempts)
+}
+
+// updateLocationFieldAttempts is the number of attempts to update the field
+// of location being modified concurrently
+const updateLocationFieldAttempts = 3
+
+// updateLocationField updates the field in a transaction which is aborted if
+// the location is modified after its existence is checked, so that the field
+// of deleted or expired location does not become a hash with a single
+// field. Returns false if the transaction was aborted.
+func updateLocationField(conn redis.Conn, loc string, field string, dbField string, value string) (bool, error) {
+	key := dbRedisICAOPrefixLocation + loc
+	if _, err := conn.Do("WATCH", key); err != nil {
+		return false, fmt.Errorf("WATCH command returned error: %s", err.Error())
+	}
+	exists, err := redis.Bool(conn.Do("EXISTS", key))
+	if err != nil {
+		return false, fmt.Errorf("EXISTS command returned error: %s", err.Error())
+	}
+	if !exists {
+		conn.Do("UNWATCH")
+		return false, fmt.Errorf("Location %s does not exist", loc)
+	}
+	isCoordinate := field == LocationFieldLatitude || field == LocationFieldLongitude
+	var lat, lon float64
+	if isCoordinate {
+		// Geospatial index is updated along with the coordinate, which
+		// requires the other coordinate
+		coord, err := redis.Float64s(conn.Do("HMGET", key,
+			dbRedisICAOLocFieldLatitude, dbRedisICAOLocFieldLongitude))
+		if err != nil {
+			conn.Do("UNWATCH")
+			return false, fmt.Errorf("Unable to retreive coordinates of %s: %s", loc, err.Error())
+		}
+		lat, lon = coord[0], coord[1]
+		v, _ := strconv.ParseFloat(value, 64)
+		if field == LocationFieldLatitude {
+			lat = v
+		} else {
+			lon = v
+		}
+	}
+	conn.Send("MULTI")
+	conn.Send("HSET", key, dbField, value)
+	if isCoordinate {
+		sendGeo(conn, loc, lat, lon)
+	}
+	reply, err := conn.Do("EXEC")
+	if err != nil {
+		return false, err
+	}
+	// Nil reply means the transaction was aborted
+	return reply != nil, nil
+}
+
 // SetMETAR sets or updates single METAR for a location
 // See Database interface for details.
-func (db *DbRedis) SetMETAR(loc string, metar string, expire int64) error {
+func (db *DbRedis) SetMETAR(loc string, metar string, obsTime time.Time, expire int64) error {
 	conn := db.pool.Get()
 	defer conn.Close()
-	_, err := conn.Do("SET", dbRedisICAOPrefixMetar+loc, metar, "EX", expire)
+	conn.Send("MULTI")
+	conn.Send("SET", dbRedisICAOPrefixMetar+loc, metar, "EX", expire)
+	if obsTime.IsZero() {
+		// Observation time of the previous METAR must not be served
+		conn.Send("DEL", dbRedisICAOPrefixMetarTime+loc)
+	} else {
+		conn.Send("SET", dbRedisICAOPrefixMetarTime+loc,
+			obsTime.UTC().Format(time.RFC3339), "EX", expire)
+	}
+	_, err := conn.Do("EXEC")
 	return err
 }
 
 // SetTAF sets or updates single TAF for a location
 // See Database interface for details.
-func (db *DbRedis) SetTAF(loc string, taf string, expire int64) error {
+func (db *DbRedis) SetTAF(loc string, taf string, validFrom time.Time, validTo time.Time, expire int64) error {
 	conn := db.pool.Get()
 	defer conn.Close()
-	_, err := conn.Do("SET", dbRedisICAOPrefixTaf+loc, taf, "EX", expire)
+	conn.Send("MULTI")
+	sendSetTAF(conn, TafEntry{Location: loc, Taf: taf, ValidFrom: validFrom, ValidTo: validTo, Expire: expire})
+	_, err := conn.Do("EXEC")
 	return err
 }
 
-func (db *DbRedis) makeLocationData(loc string, s map[string]string) (*DataICAOLocation, error) {
-	var l DataICAOLocation
+// SetMETARs sets or updates multiple METARs
+// See Database interface for details.
+func (db *DbRedis) SetMETARs(entries []MetarEntry) error {
+	conn := db.pool.Get()
+	defer conn.Close()
+	// Pipeline all commands to set the METARs in a single round trip
+	for _, e := range entries {
+		conn.Send("SET", dbRedisICAOPrefixMetar+e.Location, e.Metar, "EX", e.Expire)
+		if e.ObsTime.IsZero() {
+			conn.Send("DEL", dbRedisICAOPrefixMetarTime+e.Location)
+		} else {
+			conn.Send("SET", dbRedisICAOPrefixMetarTime+e.Location,
+				e.ObsTime.UTC().Format(time.RFC3339), "EX", e.Expire)
+		}
+	}
+	return receiveAll(conn, 2*len(entries))
+}
+
+// SetTAFs sets or updates multiple TAFs
+// See Database interface for details.
+func (db *DbRedis) SetTAFs(entries []TafEntry) error {
+	conn := db.pool.Get()
+	defer conn.Close()
+	n := 0
+	for _, e := range entries {
+		n += sendSetTAF(conn, e)
+	}
+	return receiveAll(conn, n)
+}
+
+// sendSetTAF sends the commands to set TAF, its validity period and issue
+// time, and
+// returns the number of commands sent.
+func sendSetTAF(conn redis.Conn, e TafEntry) int {
+	conn.Send("SET", dbRedisICAOPrefixTaf+e.Location, e.Taf, "EX", e.Expire)
+	// Validity period and issue time of the previous TAF must not be served
+	conn.Send("DEL", dbRedisICAOPrefixTafValid+e.Location)
+	if e.ValidFrom.IsZero() && e.ValidTo.IsZero() && e.IssueTime.IsZero() {
+		return 2
+	}
+	conn.Send("HSET", dbRedisICAOPrefixTafValid+e.Location,
+		dbRedisTafValidFieldFrom, timeOrEmpty(e.ValidFrom),
+		dbRedisTafValidFieldTo, timeOrEmpty(e.ValidTo),
+		dbRedisTafValidFieldIssued, timeOrEmpty(e.IssueTime))
+	conn.Send("EXPIRE", dbRedisICAOPrefixTafValid+e.Location, e.Expire)
+	return 4
+}
+
+// receiveAll flushes the pipelined commands and receives n replies. Returns
+// the first error, if any.
+func receiveAll(conn redis.Conn, n int) error {
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+	var result error
+	for i := 0; i < n; i++ {
+		if _, err := conn.Receive(); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+// DeleteMETAR deletes METAR for a location
+// See Database interface for details.
+func (db *DbRedis) DeleteMETAR(loc string) error {
+	conn := db.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("DEL", dbRedisICAOPrefixMetar+loc, dbRedisICAOPrefixMetarTime+loc)
+	return err
+}
+
+// DeleteTAF deletes TAF for a location
+// See Database interface for details.
+func (db *DbRedis) DeleteTAF(loc string) error {
+	conn := db.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("DEL", dbRedisICAOPrefixTaf+loc, dbRedisICAOPrefixTafValid+loc)
+	return err
+}
+
+// CheckIntegrity scans the database for inconsistent data.
+// See Database interface for details.
+func (db *DbRedis) CheckIntegrity() (IntegrityReport, error) {
+	var r IntegrityReport
+	conn := db.pool.Get()
+	defer conn.Close()
+
+	err := scanLocations(conn, dbRedisICAOPrefixLocation, func(loc []string) error {
+		r.Locations += len(loc)
+		locs, err := db.getLocationStrMaps(context.Background(), loc)
+		if err != nil {
+			return err
+		}
+		for i, v := range locs {
+			if _, err := db.makeLocationData(loc[i], v); err != nil {
+				r.CorruptLocations++
+				r.CorruptLocationSamples = appendSample(r.CorruptLocationSamples, loc[i])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return r, err
+	}
+	err = scanLocations(conn, dbRedisICAOPrefixMetar, func(loc []string) error {
+		r.Metars += len(loc)
+		orphaned, err := db.getOrphaned(loc)
+		r.OrphanedMetars += len(orphaned)
+		for _, o := range orphaned {
+			r.OrphanedMetarSamples = appendSample(r.OrphanedMetarSamples, o)
+		}
+		return err
+	})
+	if err != nil {
+		return r, err
+	}
+	err = scanLocations(conn, dbRedisICAOPrefixTaf, func(loc []string) error {
+		r.Tafs += len(loc)
+		orphaned, err := db.getOrphaned(loc)
+		r.OrphanedTafs += len(orphaned)
+		for _, o := range orphaned {
+			r.OrphanedTafSamples = appendSample(r.OrphanedTafSamples, o)
+		}
+		return err
+	})
+	return r, err
+}
+
+// RepairOrphaned repairs METARs and TAFs for locations which are not in the
+// database.
+// See Database interface for details.
+func (db *DbRedis) RepairOrphaned(action RepairAction) (int, error) {
+	if action != RepairCreatePlaceholders && action != RepairDeleteOrphaned {
+		return 0, fmt.Errorf("Unknown repair action %d", action)
+	}
+	conn := db.pool.Get()
+	defer conn.Close()
+
+	// Observation time and validity period are stored along with the reports
+	companionPrefix := map[string]string{
+		dbRedisICAOPrefixMetar: dbRedisICAOPrefixMetarTime,
+		dbRedisICAOPrefixTaf:   dbRedisICAOPrefixTafValid,
+	}
+	num := 0
+	created := make(map[string]bool)
+	for _, prefix := range []string{dbRedisICAOPrefixMetar, dbRedisICAOPrefixTaf} {
+		err := scanLocations(conn, prefix, func(loc []string) error {
+			orphaned, err := db.getOrphaned(loc)
+			if err != nil {
+				return err
+			}
+			sent := 0
+			for _, o := range orphaned {
+				switch action {
+				case RepairCreatePlaceholders:
+					if created[o] {
+						continue
+					}
+					// Coordinates are unknown, so the placeholder is not
+					// added to the geospatial index
+					conn.Send("HSET", locationHashArgs(&wxtypes.DataICAOLocation{Location: o})...)
+					created[o] = true
+				case RepairDeleteOrphaned:
+					conn.Send("DEL", prefix+o, companionPrefix[prefix]+o)
+				}
+				sent++
+			}
+			if err := receiveAll(conn, sent); err != nil {
+				return err
+			}
+			num += sent
+			return nil
+		})
+		if err != nil {
+			return num, err
+		}
+	}
+	return num, nil
+}
+
+// scanLocations iterates over the keys with specified prefix using SCAN
+// command and calls f for each batch of ICAO locations with the prefix
+// removed.
+func scanLocations(conn redis.Conn, prefix string, f func(loc []string) error) error {
+	const scanCount = 1000
+	var cursor uint64
+	for {
+		loc, next, err := scanLocationsStep(conn, prefix, "", cursor, scanCount)
+		if err != nil {
+			return err
+		}
+		if len(loc) > 0 {
+			if err := f(loc); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// scanLocationsStep performs a single iteration of SCAN command over the keys
+// with specified prefix followed by locPrefix. Returns ICAO locations with
+// the prefix removed and the cursor for the next iteration, which is zero
+// when the iteration is complete.
+func scanLocationsStep(conn redis.Conn, prefix string, locPrefix string, cursor uint64, count int) ([]string, uint64, error) {
+	v, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", prefix+locPrefix+"*", "COUNT", count))
+	if err != nil {
+		return nil, 0, fmt.Errorf("SCAN command returned error: %s", err.Error())
+	}
+	var keys []string
+	if _, err := redis.Scan(v, &cursor, &keys); err != nil {
+		return nil, 0, fmt.Errorf("Unable to parse SCAN reply: %s", err.Error())
+	}
+	loc := make([]string, len(keys))
+	for i, k := range keys {
+		loc[i] = strings.TrimPrefix(k, prefix)
+	}
+	return loc, cursor, nil
+}
+
+// getOrphaned returns the locations which have no location data in the
+// database.
+func (db *DbRedis) getOrphaned(loc []string) ([]string, error) {
+	conn := db.pool.Get()
+	defer conn.Close()
+	for _, l := range loc {
+		conn.Send("EXISTS", dbRedisICAOPrefixLocation+l)
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, l := range loc {
+		exists, err := redis.Bool(conn.Receive())
+		if err != nil {
+			return result, err
+		}
+		if !exists {
+			result = append(result, l)
+		}
+	}
+	return result, nil
+}
+
+func appendSample(samples []string, loc string) []string {
+	if len(samples) >= IntegrityReportMaxSamples {
+		return samples
+	}
+	return append(samples, loc)
+}
+
+func (db *DbRedis) makeLocationData(loc string, s map[string]string) (*wxtypes.DataICAOLocation, error) {
+	var l wxtypes.DataICAOLocation
 	alt, err := strconv.Atoi(s[dbRedisICAOLocFieldAltitudeFeet])
 	if err != nil {
 		return &l, err
@@ -273,31 +1045,489 @@ func (db *DbRedis) makeLocationData(loc string, s map[string]string) (*DataICAOL
 	l.Name = s[dbRedisICAOLocFieldName]
 	l.City = s[dbRedisICAOLocFieldCity]
 	l.CountryCode = s[dbRedisICAOLocFieldCountryCode]
+	l.CountryName = s[dbRedisICAOLocFieldCountryName]
+	l.Region = s[dbRedisICAOLocFieldRegion]
+	l.Timezone = s[dbRedisICAOLocFieldTimezone]
+	if c, ok := s[dbRedisICAOLocFieldClosed]; ok {
+		closed, err := strconv.ParseBool(c)
+		if err != nil {
+			return &l, err
+		}
+		l.Closed = closed
+	}
 	l.AltitudeFeet = alt
-	l.AltitudeMeters = int(alt * 3048 / 10000)
+	l.AltitudeMeters = altitudeMeters(alt)
 	l.Latitude = lat
 	l.Longitude = lon
 	return &l, nil
 }
 
-func (db *DbRedis) getMetarStrs(loc []string) ([]string, error) {
-	conn := db.pool.Get()
+// altitudeMeters converts altitude in feet to meters, rounded to nearest.
+func altitudeMeters(feet int) int {
+	return int(math.Round(float64(feet) * 0.3048))
+}
+
+func (db *DbRedis) getLocationStrMaps(ctx context.Context, loc []string) ([]map[string]string, error) {
+	conn, err := db.getContext(ctx)
+	if err != nil {
+		return make([]map[string]string, 0), err
+	}
 	defer conn.Close()
-	var li []interface{}
+	sendLocationStrMaps(conn, loc)
+	if err := conn.Flush(); err != nil {
+		return make([]map[string]string, 0), err
+	}
+	return receiveLocationStrMaps(ctx, conn, len(loc))
+}
+
+// sendLocationStrMaps pipelines HGETALL commands for the location hashes;
+// the replies are received with receiveLocationStrMaps.
+func sendLocationStrMaps(conn redis.Conn, loc []string) {
 	for _, l := range loc {
-		li = append(li, dbRedisICAOPrefixMetar+l)
+		conn.Send("HGETALL", dbRedisICAOPrefixLocation+l)
 	}
-	return redis.Strings(conn.Do("MGET", li...))
 }
 
-func (db *DbRedis) getTafStrs(loc []string) ([]string, error) {
-	conn := db.pool.Get()
+func receiveLocationStrMaps(ctx context.Context, conn *ctxConn, n int) ([]map[string]string, error) {
+	result := make([]map[string]string, n)
+	for i := 0; i < n; i++ {
+		v, err := redis.StringMap(receiveContext(ctx, conn))
+		if err != nil {
+			return make([]map[string]string, 0), err
+		}
+		result[i] = v
+	}
+	return result, nil
+}
+
+// getMetarStrs retreives METARs and their observation times.
+func (db *DbRedis) getMetarStrs(ctx context.Context, loc []string) ([]string, []string, error) {
+	conn, err := db.getContext(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+	conn.Send("MGET", prefixedKeys(dbRedisICAOPrefixMetar, loc)...)
+	conn.Send("MGET", prefixedKeys(dbRedisICAOPrefixMetarTime, loc)...)
+	if err := conn.Flush(); err != nil {
+		return nil, nil, err
+	}
+	metars, err := redis.Strings(receiveContext(ctx, conn))
+	if err != nil {
+		return nil, nil, err
+	}
+	metarTimes, err := redis.Strings(receiveContext(ctx, conn))
+	if err != nil {
+		return nil, nil, err
+	}
+	return metars, metarTimes, nil
+}
+
+// getTafStrs retreives TAFs and their validity periods.
+func (db *DbRedis) getTafStrs(ctx context.Context, loc []string) ([]string, []tafTimes, error) {
+	conn, err := db.getContext(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
 	defer conn.Close()
+	if err := conn.Send("MGET", prefixedKeys(dbRedisICAOPrefixTaf, loc)...); err != nil {
+		return nil, nil, err
+	}
+	sendTafValidity(conn, loc)
+	if err := conn.Flush(); err != nil {
+		return nil, nil, err
+	}
+	tafs, err := redis.Strings(receiveContext(ctx, conn))
+	if err != nil {
+		return nil, nil, err
+	}
+	tafValid, err := receiveTafValidity(ctx, conn, len(loc))
+	if err != nil {
+		return nil, nil, err
+	}
+	return tafs, tafValid, nil
+}
+
+func sendTafValidity(conn redis.Conn, loc []string) {
+	for _, l := range loc {
+		conn.Send("HMGET", dbRedisICAOPrefixTafValid+l,
+			dbRedisTafValidFieldFrom, dbRedisTafValidFieldTo, dbRedisTafValidFieldIssued)
+	}
+}
+
+// tafTimes are the start and the end of validity period and the issue time
+// of TAF; empty strings if not known.
+type tafTimes [3]string
+
+// set initialises TafValidFrom, TafValidTo and TafIssueTime fields.
+func (t tafTimes) set(ld *wxtypes.DataICAOLocation) {
+	ld.TafValidFrom, ld.TafValidTo, ld.TafIssueTime = t[0], t[1], t[2]
+}
+
+// receiveTafValidity receives the validity periods and issue times of n
+// TAFs.
+func receiveTafValidity(ctx context.Context, conn *ctxConn, n int) ([]tafTimes, error) {
+	result := make([]tafTimes, n)
+	for i := 0; i < n; i++ {
+		v, err := redis.Strings(receiveContext(ctx, conn))
+		if err != nil {
+			return make([]tafTimes, 0), err
+		}
+		if len(v) == len(tafTimes{}) {
+			copy(result[i][:], v)
+		}
+	}
+	return result, nil
+}
+
+// ctxConn is a connection which receives the replies with receiveContext.
+// Redigo cannot interrupt a reply being read from a pooled connection, so
+// when ctx is done the pending reply is abandoned: the caller returns
+// immediately, and the connection is returned to the pool only after the
+// abandoned reply is received or the read times out.
+type ctxConn struct {
+	redis.Conn
+	// abandoned is closed when the abandoned reply is received; nil if
+	// no reply was abandoned
+	abandoned chan struct{}
+}
+
+// getContext gets a connection from the pool, waiting for it no longer than
+// ctx allows.
+func (db *DbRedis) getContext(ctx context.Context) (*ctxConn, error) {
+	conn, err := db.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &ctxConn{Conn: conn}, nil
+}
+
+// Close returns the connection to the pool, without waiting for the reply
+// abandoned by receiveContext.
+func (c *ctxConn) Close() error {
+	if c.abandoned == nil {
+		return c.Conn.Close()
+	}
+	go func() {
+		<-c.abandoned
+		c.Conn.Close()
+	}()
+	return nil
+}
+
+// receiveContext receives a single pipelined reply. Returns context error if
+// ctx is done before the reply is received; if ctx has a deadline, waiting
+// for the reply is limited by the deadline. The connection must not be used
+// other than closed after the error is returned.
+func receiveContext(ctx context.Context, conn *ctxConn) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var timeout time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+		if timeout <= 0 {
+			// Zero timeout would mean no timeout at all
+			return nil, context.DeadlineExceeded
+		}
+	}
+	var reply interface{}
+	var err error
+	done := make(chan struct{})
+	go func() {
+		if timeout > 0 {
+			reply, err = redis.ReceiveWithTimeout(conn.Conn, timeout)
+		} else {
+			reply, err = conn.Conn.Receive()
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+		return reply, err
+	case <-ctx.Done():
+		conn.abandoned = done
+		return nil, ctx.Err()
+	}
+}
+
+func prefixedKeys(prefix string, loc []string) []interface{} {
 	var li []interface{}
 	for _, l := range loc {
-		li = append(li, dbRedisICAOPrefixTaf+l)
+		li = append(li, prefix+l)
 	}
-	return redis.Strings(conn.Do("MGET", li...))
+	return li
+}
+
+// ListLocations retreives a page of ICAO locations.
+// See Database interface for details.
+func (db *DbRedis) ListLocations(prefix string, cursor uint64, count int) ([]string, uint64, error) {
+	conn := db.pool.Get()
+	defer conn.Close()
+	return scanLocationsStep(conn, dbRedisICAOPrefixLocation, prefix, cursor, count)
+}
+
+// GetNearestLocations retreives location data for the nearest locations.
+// See Database interface for details.
+func (db *DbRedis) GetNearestLocations(lat float64, lon float64, n int) ([]*wxtypes.DataICAOLocation, error) {
+	// Half of Earth circumference, enough to cover any point
+	const maxRadiusKm = 20038
+	if n <= 0 {
+		return make([]*wxtypes.DataICAOLocation, 0), nil
+	}
+	conn := db.pool.Get()
+	defer conn.Close()
+	v, err := redis.Values(conn.Do("GEORADIUS", dbRedisKeyGeo, lon, lat, maxRadiusKm, "km",
+		"WITHDIST", "COUNT", n, "ASC"))
+	if err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), fmt.Errorf("GEORADIUS command returned error: %s", err.Error())
+	}
+	loc := make([]string, len(v))
+	dist := make(map[string]float64, len(v))
+	for i := range v {
+		var l string
+		var d float64
+		item, err := redis.Values(v[i], nil)
+		if err == nil {
+			_, err = redis.Scan(item, &l, &d)
+		}
+		if err != nil {
+			return make([]*wxtypes.DataICAOLocation, 0), fmt.Errorf("Unable to parse GEORADIUS reply: %s", err.Error())
+		}
+		loc[i], dist[l] = l, d
+	}
+	// Locations are retreived in the same order, i.e. by distance
+	ld, err := db.GetLocationInfo(loc)
+	if err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	for _, l := range ld {
+		l.DistanceKm = dist[l.Location]
+	}
+	return ld, nil
+}
+
+// GetLocationsInBox retreives location data for the locations within the
+// bounding box. Since Redis searches by radius, the locations within the
+// circle around the box are retreived and then filtered by the bounds.
+// See Database interface for details.
+func (db *DbRedis) GetLocationsInBox(minLat float64, minLon float64, maxLat float64, maxLon float64) ([]*wxtypes.DataICAOLocation, error) {
+	lat, lon := (minLat+maxLat)/2, (minLon+maxLon)/2
+	// The box is wider at the latitude closer to the equator, margin
+	// covers the precision of Redis geospatial index
+	const marginKm = 1
+	radius := math.Max(util.DistanceKm(lat, lon, minLat, minLon),
+		util.DistanceKm(lat, lon, maxLat, maxLon)) + marginKm
+	conn := db.pool.Get()
+	defer conn.Close()
+	v, err := redis.Values(conn.Do("GEORADIUS", dbRedisKeyGeo, lon, lat, radius, "km", "WITHCOORD"))
+	if err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), fmt.Errorf("GEORADIUS command returned error: %s", err.Error())
+	}
+	var loc []string
+	for i := range v {
+		var l string
+		var coord []interface{}
+		item, err := redis.Values(v[i], nil)
+		if err == nil {
+			_, err = redis.Scan(item, &l, &coord)
+		}
+		var c []float64
+		if err == nil {
+			c, err = redis.Float64s(coord, nil)
+		}
+		if err == nil && len(c) != 2 {
+			err = fmt.Errorf("%d coordinates instead of 2", len(c))
+		}
+		if err != nil {
+			return make([]*wxtypes.DataICAOLocation, 0), fmt.Errorf("Unable to parse GEORADIUS reply: %s", err.Error())
+		}
+		if inBox(&wxtypes.DataICAOLocation{Latitude: c[1], Longitude: c[0]}, minLat, minLon, maxLat, maxLon) {
+			loc = append(loc, l)
+		}
+	}
+	sort.Strings(loc)
+	if len(loc) == 0 {
+		return make([]*wxtypes.DataICAOLocation, 0), nil
+	}
+	return db.GetLocationInfo(loc)
+}
+
+// inBox checks whether the location is within the bounding box.
+func inBox(ld *wxtypes.DataICAOLocation, minLat float64, minLon float64, maxLat float64, maxLon float64) bool {
+	return ld.Latitude >= minLat && ld.Latitude <= maxLat &&
+		ld.Longitude >= minLon && ld.Longitude <= maxLon
+}
+
+// Ping checks whether Redis server is reachable.
+// See Database interface for details.
+func (db *DbRedis) Ping() error {
+	conn := db.pool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("PING"); err != nil {
+		return fmt.Errorf("PING command returned error: %s", err.Error())
+	}
+	return nil
+}
+
+// Close closes Redis connection pool.
+// See Database interface for details.
+func (db *DbRedis) Close() error {
+	return db.pool.Close()
+}
+
+// GetDataEpoch retreives data epoch.
+// See Database interface for details.
+func (db *DbRedis) GetDataEpoch() (int64, error) {
+	conn := db.pool.Get()
+	defer conn.Close()
+	epoch, err := redis.Int64(conn.Do("GET", dbRedisKeyDataEpoch))
+	if err == redis.ErrNil {
+		return 0, nil
+	}
+	return epoch, err
+}
+
+// IncrementDataEpoch increments data epoch.
+// See Database interface for details.
+func (db *DbRedis) IncrementDataEpoch() (int64, error) {
+	conn := db.pool.Get()
+	defer conn.Close()
+	return redis.Int64(conn.Do("INCR", dbRedisKeyDataEpoch))
+}
+
+// GetCounts retreives the number of locations, METARs and TAFs. The keys are
+// counted using SCAN so that Redis is not blocked in large databases.
+// See Database interface for details.
+func (db *DbRedis) GetCounts() (int, int, int, error) {
+	conn := db.pool.Get()
+	defer conn.Close()
+	prefixes := []string{dbRedisICAOPrefixLocation, dbRedisICAOPrefixMetar, dbRedisICAOPrefixTaf}
+	counts := make([]int, len(prefixes))
+	for i, p := range prefixes {
+		err := scanLocations(conn, p, func(loc []string) error {
+			counts[i] += len(loc)
+			return nil
+		})
+		if err != nil {
+			return 0, 0, 0, err
+		}
+	}
+	return counts[0], counts[1], counts[2], nil
+}
+
+// GetCountryCounts retreives the number of locations and the number of
+// locations with current METAR for each country. The location keys are
+// scanned, so this is expensive for large databases.
+// See Database interface for details.
+func (db *DbRedis) GetCountryCounts() (map[string]CountryCount, error) {
+	conn := db.pool.Get()
+	defer conn.Close()
+	result := make(map[string]CountryCount)
+	// SCAN may return the same key more than once
+	counted := make(map[string]bool)
+	err := scanLocations(conn, dbRedisICAOPrefixLocation, func(loc []string) error {
+		var batch []string
+		for _, l := range loc {
+			if !counted[l] {
+				counted[l] = true
+				batch = append(batch, l)
+			}
+		}
+		for _, l := range batch {
+			conn.Send("HGET", dbRedisICAOPrefixLocation+l, dbRedisICAOLocFieldCountryCode)
+			conn.Send("EXISTS", dbRedisICAOPrefixMetar+l)
+		}
+		if err := conn.Flush(); err != nil {
+			return err
+		}
+		for range batch {
+			country, err := redis.String(conn.Receive())
+			if err != nil && err != redis.ErrNil {
+				return fmt.Errorf("HGET command returned error: %s", err.Error())
+			}
+			reporting, err := redis.Bool(conn.Receive())
+			if err != nil {
+				return fmt.Errorf("EXISTS command returned error: %s", err.Error())
+			}
+			c := result[country]
+			c.Locations++
+			if reporting {
+				c.Reporting++
+			}
+			result[country] = c
+		}
+		return nil
+	})
+	if err != nil {
+		return make(map[string]CountryCount), err
+	}
+	return result, nil
+}
+
+// RedisPoolConfig specifies the parameters of Redis connection pool created by
+// NewRedisPool. Zero timeouts mean no timeout.
+type RedisPoolConfig struct {
+	// Server is Redis server address, such as ":6379"
+	Server string
+	// MaxIdle and MaxActive limit the number of idle and active
+	// connections in the pool
+	MaxIdle   int
+	MaxActive int
+	// ConnectTimeout limits the time of dialing a new connection
+	ConnectTimeout time.Duration
+	// ReadTimeout and WriteTimeout limit the time of reading a single
+	// command reply and writing a single command, so that a slow command
+	// cannot block indefinitely
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// Log receives dial errors; the logger set with SetLogger is used if
+	// nil
+	Log util.Logger
+}
+
+// NewRedisPool creates Redis connection pool. The connections are dialed on
+// demand.
+func NewRedisPool(cfg RedisPoolConfig) *redis.Pool {
+	return &redis.Pool{
+		MaxIdle:   cfg.MaxIdle,
+		MaxActive: cfg.MaxActive,
+		Dial: func() (redis.Conn, error) {
+			c, err := redis.Dial("tcp", cfg.Server,
+				redis.DialConnectTimeout(cfg.ConnectTimeout),
+				redis.DialReadTimeout(cfg.ReadTimeout),
+				redis.DialWriteTimeout(cfg.WriteTimeout))
+			if err != nil {
+				l := cfg.Log
+				if l == nil {
+					l = logger
+				}
+				l.Error("Unable to connect to Redis server %s: %s", cfg.Server, err.Error())
+			}
+			return c, err
+		},
+	}
+}
+
+// WarmupRedisPool dials n connections in advance, verifies them with PING
+// and returns them to the pool as idle connections, so that the first
+// requests do not need to wait for dialing. The number of connections kept
+// idle is limited by the pool's MaxIdle.
+func WarmupRedisPool(p *redis.Pool, n int) error {
+	conns := make([]redis.Conn, 0, n)
+	defer func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+	for i := 0; i < n; i++ {
+		c := p.Get()
+		conns = append(conns, c)
+		if _, err := c.Do("PING"); err != nil {
+			return fmt.Errorf("PING command returned error: %s", err.Error())
+		}
+	}
+	return nil
 }
 
 // NewDbAccessRedis is a factory function to create an instance of
@@ -0,0 +1,594 @@
+/*
+* Copyright (C) 2020 Nick Naumenko (https://gitlab.com/nnaumenko)
+* All rights reserved.
+* This software may be modified and distributed under the terms
+* of the MIT license. See the LICENSE file for details.
+ */
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nnaumenko/wx/internal/util"
+	"github.com/nnaumenko/wx/pkg/wxtypes"
+)
+
+// InMemoryDB is an implementation of Database which stores data in memory.
+// Intended for tests and local development; the data are lost when the
+// process exits.
+type InMemoryDB struct {
+	mu        sync.RWMutex
+	locations map[string]wxtypes.DataICAOLocation
+	metars    map[string]inMemoryReport
+	tafs      map[string]inMemoryReport
+	epoch     int64
+}
+
+type inMemoryReport struct {
+	report  string
+	expires time.Time
+	// obsTime is METAR observation time in RFC3339 format, empty if not
+	// known or if the report is TAF
+	obsTime string
+	// validFrom and validTo are TAF validity period in RFC3339 format,
+	// empty if not known or if the report is METAR
+	validFrom string
+	validTo   string
+	// issueTime is TAF issue time in RFC3339 format, empty if not known or
+	// if the report is METAR
+	issueTime string
+}
+
+// GetICAOLocationData retreives selected data fields for ICAO locations.
+// See Database interface for details.
+func (db *InMemoryDB) GetICAOLocationData(loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	now := time.Now()
+	var result []*wxtypes.DataICAOLocation
+	for _, l := range loc {
+		if ld, ok := db.getLocation(l); ok {
+			m := getReport(db.metars, l, now)
+			ld.Metar, ld.MetarObservationTime = m.report, m.obsTime
+			t := getReport(db.tafs, l, now)
+			ld.Taf, ld.TafValidFrom, ld.TafValidTo, ld.TafIssueTime = t.report, t.validFrom, t.validTo, t.issueTime
+			result = append(result, ld)
+		}
+	}
+	return result, nil
+}
+
+// GetLocationInfo retreives only location data for ICAO locations.
+// See Database interface for details.
+func (db *InMemoryDB) GetLocationInfo(loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	var result []*wxtypes.DataICAOLocation
+	for _, l := range loc {
+		if ld, ok := db.getLocation(l); ok {
+			result = append(result, ld)
+		}
+	}
+	return result, nil
+}
+
+// GetMETARs retreives only METAR reports for ICAO locations.
+// See Database interface for details.
+func (db *InMemoryDB) GetMETARs(loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	return db.getReports(loc, true, false), nil
+}
+
+// GetTAFs retreives only TAF reports for ICAO locations.
+// See Database interface for details.
+func (db *InMemoryDB) GetTAFs(loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	return db.getReports(loc, false, true), nil
+}
+
+// GetMETARsTAFs retreives only METAR and TAF reports for ICAO locations.
+// See Database interface for details.
+func (db *InMemoryDB) GetMETARsTAFs(loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	return db.getReports(loc, true, true), nil
+}
+
+// GetICAOLocationDataContext retreives selected data fields for ICAO
+// locations. The data are in memory so ctx is only checked before retreival.
+// See Database interface for details.
+func (db *InMemoryDB) GetICAOLocationDataContext(ctx context.Context, loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	if err := ctx.Err(); err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	return db.GetICAOLocationData(loc)
+}
+
+// GetLocationInfoContext retreives only location data for ICAO locations.
+// See Database interface for details.
+func (db *InMemoryDB) GetLocationInfoContext(ctx context.Context, loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	if err := ctx.Err(); err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	return db.GetLocationInfo(loc)
+}
+
+// GetMETARsContext retreives only METAR reports for ICAO locations.
+// See Database interface for details.
+func (db *InMemoryDB) GetMETARsContext(ctx context.Context, loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	if err := ctx.Err(); err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	return db.GetMETARs(loc)
+}
+
+// GetTAFsContext retreives only TAF reports for ICAO locations.
+// See Database interface for details.
+func (db *InMemoryDB) GetTAFsContext(ctx context.Context, loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	if err := ctx.Err(); err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	return db.GetTAFs(loc)
+}
+
+// GetMETARsTAFsContext retreives only METAR and TAF reports for ICAO
+// locations.
+// See Database interface for details.
+func (db *InMemoryDB) GetMETARsTAFsContext(ctx context.Context, loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	if err := ctx.Err(); err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	return db.GetMETARsTAFs(loc)
+}
+
+// GetMETARTTL retreives remaining time-to-expire of METAR for a location.
+// See Database interface for details.
+func (db *InMemoryDB) GetMETARTTL(loc string) (int64, error) {
+	return db.getReportTTL(db.metars, loc), nil
+}
+
+// GetTAFTTL retreives remaining time-to-expire of TAF for a location.
+// See Database interface for details.
+func (db *InMemoryDB) GetTAFTTL(loc string) (int64, error) {
+	return db.getReportTTL(db.tafs, loc), nil
+}
+
+// LocationExists checks whether an ICAO location exists in the database.
+// See Database interface for details.
+func (db *InMemoryDB) LocationExists(loc string) (bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	_, ok := db.locations[loc]
+	return ok, nil
+}
+
+// SetDataICAOLocation sets the location data in the database.
+// See Database interface for details.
+func (db *InMemoryDB) SetDataICAOLocation(data *wxtypes.DataICAOLocation) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.setLocation(data)
+	return nil
+}
+
+// SetDataICAOLocationIfAbsent sets the location data in the database unless
+// the location already exists.
+// See Database interface for details.
+func (db *InMemoryDB) SetDataICAOLocationIfAbsent(data *wxtypes.DataICAOLocation) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.locations[data.Location]; !ok {
+		db.setLocation(data)
+	}
+	return nil
+}
+
+// UpdateLocationField updates a single field of location data.
+// See Database interface for details.
+func (db *InMemoryDB) UpdateLocationField(loc string, field string, value string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	ld, ok := db.locations[loc]
+	if !ok {
+		return fmt.Errorf("Location %s does not exist", loc)
+	}
+	var err error
+	switch field {
+	case LocationFieldName:
+		ld.Name = value
+	case LocationFieldCity:
+		ld.City = value
+	case LocationFieldCountryCode:
+		ld.CountryCode = value
+	case LocationFieldCountryName:
+		ld.CountryName = value
+	case LocationFieldRegion:
+		ld.Region = value
+	case LocationFieldLatitude:
+		ld.Latitude, err = strconv.ParseFloat(value, 64)
+	case LocationFieldLongitude:
+		ld.Longitude, err = strconv.ParseFloat(value, 64)
+	case LocationFieldAltitudeFeet:
+		ld.AltitudeFeet, err = strconv.Atoi(value)
+	case LocationFieldTimezone:
+		ld.Timezone = value
+	case LocationFieldClosed:
+		ld.Closed, err = strconv.ParseBool(value)
+	default:
+		return fmt.Errorf("Unknown location field %s", field)
+	}
+	if err != nil {
+		return fmt.Errorf("Invalid value %s for location field %s: %s", value, field, err.Error())
+	}
+	db.locations[loc] = ld
+	return nil
+}
+
+// SetMETAR sets or updates single METAR for a location
+// See Database interface for details.
+func (db *InMemoryDB) SetMETAR(loc string, metar string, obsTime time.Time, expire int64) error {
+	var t string
+	if !obsTime.IsZero() {
+		t = obsTime.UTC().Format(time.RFC3339)
+	}
+	return db.setReport(db.metars, loc, inMemoryReport{report: metar, obsTime: t}, expire)
+}
+
+// SetTAF sets or updates single TAF for a location
+// See Database interface for details.
+func (db *InMemoryDB) SetTAF(loc string, taf string, validFrom time.Time, validTo time.Time, expire int64) error {
+	return db.setTAF(TafEntry{Location: loc, Taf: taf, ValidFrom: validFrom, ValidTo: validTo, Expire: expire})
+}
+
+func (db *InMemoryDB) setTAF(e TafEntry) error {
+	r := inMemoryReport{
+		report:    e.Taf,
+		validFrom: timeOrEmpty(e.ValidFrom),
+		validTo:   timeOrEmpty(e.ValidTo),
+		issueTime: timeOrEmpty(e.IssueTime),
+	}
+	return db.setReport(db.tafs, e.Location, r, e.Expire)
+}
+
+// SetMETARs sets or updates multiple METARs
+// See Database interface for details.
+func (db *InMemoryDB) SetMETARs(entries []MetarEntry) error {
+	var result error
+	for _, e := range entries {
+		if err := db.SetMETAR(e.Location, e.Metar, e.ObsTime, e.Expire); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+// SetTAFs sets or updates multiple TAFs
+// See Database interface for details.
+func (db *InMemoryDB) SetTAFs(entries []TafEntry) error {
+	var result error
+	for _, e := range entries {
+		if err := db.setTAF(e); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+// DeleteMETAR deletes METAR for a location
+// See Database interface for details.
+func (db *InMemoryDB) DeleteMETAR(loc string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	delete(db.metars, loc)
+	return nil
+}
+
+// DeleteTAF deletes TAF for a location
+// See Database interface for details.
+func (db *InMemoryDB) DeleteTAF(loc string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	delete(db.tafs, loc)
+	return nil
+}
+
+// CheckIntegrity scans the database for inconsistent data. Location data
+// cannot be corrupt in memory, so only orphaned reports are reported.
+// See Database interface for details.
+func (db *InMemoryDB) CheckIntegrity() (IntegrityReport, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	now := time.Now()
+	r := IntegrityReport{Locations: len(db.locations)}
+	for l, m := range db.metars {
+		if m.expires.After(now) {
+			r.Metars++
+			if _, ok := db.locations[l]; !ok {
+				r.OrphanedMetars++
+				r.OrphanedMetarSamples = appendSample(r.OrphanedMetarSamples, l)
+			}
+		}
+	}
+	for l, t := range db.tafs {
+		if t.expires.After(now) {
+			r.Tafs++
+			if _, ok := db.locations[l]; !ok {
+				r.OrphanedTafs++
+				r.OrphanedTafSamples = appendSample(r.OrphanedTafSamples, l)
+			}
+		}
+	}
+	return r, nil
+}
+
+// RepairOrphaned repairs METARs and TAFs for locations which are not in the
+// database.
+// See Database interface for details.
+func (db *InMemoryDB) RepairOrphaned(action RepairAction) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	now := time.Now()
+	num := 0
+	for _, reports := range []map[string]inMemoryReport{db.metars, db.tafs} {
+		for l, r := range reports {
+			if _, ok := db.locations[l]; ok || !r.expires.After(now) {
+				continue
+			}
+			switch action {
+			case RepairCreatePlaceholders:
+				db.locations[l] = wxtypes.DataICAOLocation{Location: l}
+			case RepairDeleteOrphaned:
+				delete(reports, l)
+			default:
+				return num, fmt.Errorf("Unknown repair action %d", action)
+			}
+			num++
+		}
+	}
+	return num, nil
+}
+
+// ListLocations retreives a page of ICAO locations. The cursor is the
+// offset of the page in the sorted list of locations.
+// See Database interface for details.
+func (db *InMemoryDB) ListLocations(prefix string, cursor uint64, count int) ([]string, uint64, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	var all []string
+	for l := range db.locations {
+		if strings.HasPrefix(l, prefix) {
+			all = append(all, l)
+		}
+	}
+	sort.Strings(all)
+	if count <= 0 {
+		count = 10
+	}
+	if cursor >= uint64(len(all)) {
+		return []string{}, 0, nil
+	}
+	end := cursor + uint64(count)
+	if end >= uint64(len(all)) {
+		return all[cursor:], 0, nil
+	}
+	return all[cursor:end], end, nil
+}
+
+// GetNearestLocations retreives location data for the nearest locations.
+// See Database interface for details.
+func (db *InMemoryDB) GetNearestLocations(lat float64, lon float64, n int) ([]*wxtypes.DataICAOLocation, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	var result []*wxtypes.DataICAOLocation
+	for l := range db.locations {
+		ld, _ := db.getLocation(l)
+		if math.Abs(ld.Latitude) > GeoMaxLatitude {
+			continue
+		}
+		ld.DistanceKm = util.DistanceKm(lat, lon, ld.Latitude, ld.Longitude)
+		result = append(result, ld)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].DistanceKm < result[j].DistanceKm
+	})
+	if n < 0 {
+		n = 0
+	}
+	if len(result) > n {
+		result = result[:n]
+	}
+	return result, nil
+}
+
+// GetLocationsInBox retreives location data for the locations within the
+// bounding box.
+// See Database interface for details.
+func (db *InMemoryDB) GetLocationsInBox(minLat float64, minLon float64, maxLat float64, maxLon float64) ([]*wxtypes.DataICAOLocation, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	result := make([]*wxtypes.DataICAOLocation, 0)
+	for l := range db.locations {
+		ld, _ := db.getLocation(l)
+		if math.Abs(ld.Latitude) > GeoMaxLatitude || !inBox(ld, minLat, minLon, maxLat, maxLon) {
+			continue
+		}
+		result = append(result, ld)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Location < result[j].Location
+	})
+	return result, nil
+}
+
+// Ping always succeeds since the data are in memory.
+// See Database interface for details.
+func (db *InMemoryDB) Ping() error {
+	return nil
+}
+
+// Close does nothing since the data are in memory.
+// See Database interface for details.
+func (db *InMemoryDB) Close() error {
+	return nil
+}
+
+// GetDataEpoch retreives data epoch.
+// See Database interface for details.
+func (db *InMemoryDB) GetDataEpoch() (int64, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.epoch, nil
+}
+
+// IncrementDataEpoch increments data epoch.
+// See Database interface for details.
+func (db *InMemoryDB) IncrementDataEpoch() (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.epoch++
+	return db.epoch, nil
+}
+
+// GetCounts retreives the number of locations, METARs and TAFs.
+// See Database interface for details.
+func (db *InMemoryDB) GetCounts() (int, int, int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	now := time.Now()
+	return len(db.locations), countReports(db.metars, now), countReports(db.tafs, now), nil
+}
+
+// GetCountryCounts retreives the number of locations and the number of
+// locations with current METAR for each country.
+// See Database interface for details.
+func (db *InMemoryDB) GetCountryCounts() (map[string]CountryCount, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	now := time.Now()
+	result := make(map[string]CountryCount)
+	for l, ld := range db.locations {
+		c := result[ld.CountryCode]
+		c.Locations++
+		if m, ok := db.metars[l]; ok && m.expires.After(now) {
+			c.Reporting++
+		}
+		result[ld.CountryCode] = c
+	}
+	return result, nil
+}
+
+// getLocation returns a copy of stored location data. Must be called with
+// the mutex locked.
+func (db *InMemoryDB) getLocation(loc string) (*wxtypes.DataICAOLocation, bool) {
+	ld, ok := db.locations[loc]
+	if !ok {
+		return nil, false
+	}
+	ld.AltitudeMeters = altitudeMeters(ld.AltitudeFeet)
+	return &ld, true
+}
+
+// setLocation stores saved fields of location data. Must be called with the
+// mutex locked.
+func (db *InMemoryDB) setLocation(data *wxtypes.DataICAOLocation) {
+	db.locations[data.Location] = wxtypes.DataICAOLocation{
+		Location:     data.Location,
+		Name:         data.Name,
+		City:         data.City,
+		CountryCode:  data.CountryCode,
+		CountryName:  data.CountryName,
+		Region:       data.Region,
+		Latitude:     data.Latitude,
+		Longitude:    data.Longitude,
+		AltitudeFeet: data.AltitudeFeet,
+		Timezone:     data.Timezone,
+		Closed:       data.Closed,
+	}
+}
+
+func (db *InMemoryDB) getReports(loc []string, metar bool, taf bool) []*wxtypes.DataICAOLocation {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	now := time.Now()
+	var result []*wxtypes.DataICAOLocation
+	for _, l := range loc {
+		ld := wxtypes.DataICAOLocation{Location: l}
+		if metar {
+			m := getReport(db.metars, l, now)
+			ld.Metar, ld.MetarObservationTime = m.report, m.obsTime
+		}
+		if taf {
+			t := getReport(db.tafs, l, now)
+			ld.Taf, ld.TafValidFrom, ld.TafValidTo, ld.TafIssueTime = t.report, t.validFrom, t.validTo, t.issueTime
+		}
+		if len(ld.Metar) > 0 || len(ld.Taf) > 0 {
+			result = append(result, &ld)
+		}
+	}
+	return result
+}
+
+// getReportTTL returns remaining time-to-expire of the report in seconds or
+// -2 if the report is not found or expired. The reports stored in memory
+// always expire, so -1 is never returned.
+func (db *InMemoryDB) getReportTTL(reports map[string]inMemoryReport, loc string) int64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	r, ok := reports[loc]
+	if !ok {
+		return -2
+	}
+	ttl := time.Until(r.expires)
+	if ttl <= 0 {
+		return -2
+	}
+	return int64(ttl / time.Second)
+}
+
+// setReport stores the report which expires in expire seconds.
+func (db *InMemoryDB) setReport(reports map[string]inMemoryReport, loc string, r inMemoryReport, expire int64) error {
+	if expire <= 0 {
+		return fmt.Errorf("Invalid expire time %d", expire)
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	r.expires = time.Now().Add(time.Duration(expire) * time.Second)
+	reports[loc] = r
+	return nil
+}
+
+// getReport returns the report or an empty report if the report is not
+// found or expired. Must be called with the mutex locked.
+func getReport(reports map[string]inMemoryReport, loc string, now time.Time) inMemoryReport {
+	r, ok := reports[loc]
+	if !ok || !r.expires.After(now) {
+		return inMemoryReport{}
+	}
+	return r
+}
+
+// NewInMemoryDB is a factory function to create an empty instance of
+// InMemoryDB.
+func NewInMemoryDB() Database {
+	return &InMemoryDB{
+		locations: make(map[string]wxtypes.DataICAOLocation),
+		metars:    make(map[string]inMemoryReport),
+		tafs:      make(map[string]inMemoryReport),
+	}
+}
+
+// countReports returns the number of reports which are not expired. Must be
+// called with the mutex locked.
+func countReports(reports map[string]inMemoryReport, now time.Time) int {
+	n := 0
+	for _, r := range reports {
+		if r.expires.After(now) {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,705 @@
+/*
+* Copyright (C) 2020 Nick Naumenko (https://gitlab.com/nnaumenko)
+* All rights reserved.
+* This software may be modified and distributed under the terms
+* of the MIT license. See the LICENSE file for details.
+ */
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nnaumenko/wx/internal/util"
+	"github.com/nnaumenko/wx/pkg/wxtypes"
+
+	// Registers postgres driver for database/sql
+	_ "github.com/lib/pq"
+)
+
+const (
+	// postgresSweepInterval is how often expired reports are deleted
+	postgresSweepInterval = 1 * time.Minute
+	// postgresMaxOpenConnections and postgresMaxIdleConnections limit the
+	// connections kept by database/sql for reuse
+	postgresMaxOpenConnections = 50
+	postgresMaxIdleConnections = 10
+)
+
+// postgresSchema is the migration which creates the tables unless they
+// already exist and adds the columns missing in the tables created by older
+// versions. Location columns are named the same as LocationField
+// constants.
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS locations (
+	location      TEXT PRIMARY KEY,
+	name          TEXT NOT NULL DEFAULT '',
+	city          TEXT NOT NULL DEFAULT '',
+	country_code  TEXT NOT NULL DEFAULT '',
+	latitude      DOUBLE PRECISION NOT NULL DEFAULT 0,
+	longitude     DOUBLE PRECISION NOT NULL DEFAULT 0,
+	altitude_feet INTEGER NOT NULL DEFAULT 0,
+	timezone      TEXT NOT NULL DEFAULT '',
+	closed        BOOLEAN NOT NULL DEFAULT FALSE
+);
+ALTER TABLE locations ADD COLUMN IF NOT EXISTS region TEXT NOT NULL DEFAULT '';
+ALTER TABLE locations ADD COLUMN IF NOT EXISTS country_name TEXT NOT NULL DEFAULT '';
+CREATE TABLE IF NOT EXISTS metars (
+	location   TEXT PRIMARY KEY,
+	metar      TEXT NOT NULL,
+	obs_time   TEXT NOT NULL DEFAULT '',
+	expires_at TIMESTAMPTZ NOT NULL
+);
+CREATE TABLE IF NOT EXISTS tafs (
+	location   TEXT PRIMARY KEY,
+	taf        TEXT NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+ALTER TABLE tafs ADD COLUMN IF NOT EXISTS valid_from TEXT NOT NULL DEFAULT '';
+ALTER TABLE tafs ADD COLUMN IF NOT EXISTS valid_to TEXT NOT NULL DEFAULT '';
+ALTER TABLE tafs ADD COLUMN IF NOT EXISTS issue_time TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS metars_expires_at ON metars (expires_at);
+CREATE INDEX IF NOT EXISTS tafs_expires_at ON tafs (expires_at);
+CREATE TABLE IF NOT EXISTS epoch (
+	id    INTEGER PRIMARY KEY CHECK (id = 0),
+	epoch BIGINT NOT NULL
+);
+`
+
+// postgresDistanceKm is SQL expression for the distance in kilometers from
+// the point specified by $1 (latitude) and $2 (longitude) to the location,
+// calculated the same way as util.DistanceKm.
+const postgresDistanceKm = "2 * 6372.7976 * asin(sqrt(" +
+	"power(sin(radians(latitude - $1) / 2), 2) + " +
+	"cos(radians($1)) * cos(radians(latitude)) * power(sin(radians(longitude - $2) / 2), 2)))"
+
+// DbPostgres is an implementation of Database which stores data in
+// PostgreSQL database. Expired reports are not served and are periodically
+// deleted.
+type DbPostgres struct {
+	db     *sql.DB
+	cancel context.CancelFunc
+	swept  <-chan struct{}
+}
+
+// GetICAOLocationData retreives selected data fields for ICAO locations.
+// See Database interface for details.
+func (db *DbPostgres) GetICAOLocationData(loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	return db.GetICAOLocationDataContext(context.Background(), loc)
+}
+
+// GetICAOLocationDataContext retreives selected data fields for ICAO
+// locations.
+// See Database interface for details.
+func (db *DbPostgres) GetICAOLocationDataContext(ctx context.Context, loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	locs, err := db.getLocations(ctx, loc)
+	if err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	metars, err := db.getMetars(ctx, loc)
+	if err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	tafs, err := db.getTafs(ctx, loc)
+	if err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	var result []*wxtypes.DataICAOLocation
+	for _, l := range loc {
+		if ld, ok := locs[l]; ok {
+			if m, ok := metars[l]; ok {
+				ld.Metar, ld.MetarObservationTime = m.Metar, m.MetarObservationTime
+			}
+			if t, ok := tafs[l]; ok {
+				ld.Taf, ld.TafValidFrom, ld.TafValidTo, ld.TafIssueTime = t.Taf, t.TafValidFrom, t.TafValidTo, t.TafIssueTime
+			}
+			result = append(result, ld)
+		}
+	}
+	return result, nil
+}
+
+// GetLocationInfo retreives only location data for ICAO locations.
+// See Database interface for details.
+func (db *DbPostgres) GetLocationInfo(loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	return db.GetLocationInfoContext(context.Background(), loc)
+}
+
+// GetLocationInfoContext retreives only location data for ICAO locations.
+// See Database interface for details.
+func (db *DbPostgres) GetLocationInfoContext(ctx context.Context, loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	locs, err := db.getLocations(ctx, loc)
+	if err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	return inOrder(loc, locs), nil
+}
+
+// GetMETARs retreives only METAR reports for ICAO locations.
+// See Database interface for details.
+func (db *DbPostgres) GetMETARs(loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	return db.GetMETARsContext(context.Background(), loc)
+}
+
+// GetMETARsContext retreives only METAR reports for ICAO locations.
+// See Database interface for details.
+func (db *DbPostgres) GetMETARsContext(ctx context.Context, loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	metars, err := db.getMetars(ctx, loc)
+	if err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	return inOrder(loc, metars), nil
+}
+
+// GetTAFs retreives only TAF reports for ICAO locations.
+// See Database interface for details.
+func (db *DbPostgres) GetTAFs(loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	return db.GetTAFsContext(context.Background(), loc)
+}
+
+// GetTAFsContext retreives only TAF reports for ICAO locations.
+// See Database interface for details.
+func (db *DbPostgres) GetTAFsContext(ctx context.Context, loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	tafs, err := db.getTafs(ctx, loc)
+	if err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	return inOrder(loc, tafs), nil
+}
+
+// GetMETARsTAFs retreives only METAR and TAF reports for ICAO locations.
+// See Database interface for details.
+func (db *DbPostgres) GetMETARsTAFs(loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	return db.GetMETARsTAFsContext(context.Background(), loc)
+}
+
+// GetMETARsTAFsContext retreives only METAR and TAF reports for ICAO
+// locations.
+// See Database interface for details.
+func (db *DbPostgres) GetMETARsTAFsContext(ctx context.Context, loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	metars, err := db.getMetars(ctx, loc)
+	if err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	tafs, err := db.getTafs(ctx, loc)
+	if err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	var result []*wxtypes.DataICAOLocation
+	for _, l := range loc {
+		m, hasMetar := metars[l]
+		t, hasTaf := tafs[l]
+		switch {
+		case hasMetar && hasTaf:
+			m.Taf, m.TafValidFrom, m.TafValidTo, m.TafIssueTime = t.Taf, t.TafValidFrom, t.TafValidTo, t.TafIssueTime
+			result = append(result, m)
+		case hasMetar:
+			result = append(result, m)
+		case hasTaf:
+			result = append(result, t)
+		}
+	}
+	return result, nil
+}
+
+// GetMETARTTL retreives remaining time-to-expire of METAR for a location.
+// See Database interface for details.
+func (db *DbPostgres) GetMETARTTL(loc string) (int64, error) {
+	return db.getReportTTL("metars", loc)
+}
+
+// GetTAFTTL retreives remaining time-to-expire of TAF for a location.
+// See Database interface for details.
+func (db *DbPostgres) GetTAFTTL(loc string) (int64, error) {
+	return db.getReportTTL("tafs", loc)
+}
+
+// LocationExists checks whether an ICAO location exists in the database.
+// See Database interface for details.
+func (db *DbPostgres) LocationExists(loc string) (bool, error) {
+	var exists bool
+	err := db.db.QueryRow("SELECT EXISTS (SELECT 1 FROM locations WHERE location = $1)", loc).Scan(&exists)
+	return exists, err
+}
+
+// SetDataICAOLocation sets the location data in the database.
+// See Database interface for details.
+func (db *DbPostgres) SetDataICAOLocation(data *wxtypes.DataICAOLocation) error {
+	return db.setLocation("ON CONFLICT (location) DO UPDATE SET "+
+		"name = EXCLUDED.name, city = EXCLUDED.city, country_code = EXCLUDED.country_code, "+
+		"latitude = EXCLUDED.latitude, longitude = EXCLUDED.longitude, "+
+		"altitude_feet = EXCLUDED.altitude_feet, timezone = EXCLUDED.timezone, "+
+		"closed = EXCLUDED.closed, region = EXCLUDED.region, "+
+		"country_name = EXCLUDED.country_name", data)
+}
+
+// SetDataICAOLocationIfAbsent sets the location data in the database unless
+// the location already exists.
+// See Database interface for details.
+func (db *DbPostgres) SetDataICAOLocationIfAbsent(data *wxtypes.DataICAOLocation) error {
+	return db.setLocation("ON CONFLICT (location) DO NOTHING", data)
+}
+
+// UpdateLocationField updates a single field of location data.
+// See Database interface for details.
+func (db *DbPostgres) UpdateLocationField(loc string, field string, value string) error {
+	var v interface{}
+	var err error
+	switch field {
+	case LocationFieldName, LocationFieldCity, LocationFieldCountryCode,
+		LocationFieldCountryName, LocationFieldRegion, LocationFieldTimezone:
+		v = value
+	case LocationFieldLatitude, LocationFieldLongitude:
+		v, err = strconv.ParseFloat(value, 64)
+	case LocationFieldAltitudeFeet:
+		v, err = strconv.Atoi(value)
+	case LocationFieldClosed:
+		v, err = strconv.ParseBool(value)
+	default:
+		return fmt.Errorf("Unknown location field %s", field)
+	}
+	if err != nil {
+		return fmt.Errorf("Invalid value %s for location field %s: %s", value, field, err.Error())
+	}
+	// Column names are the same as field names validated above
+	res, err := db.db.Exec("UPDATE locations SET "+field+" = $1 WHERE location = $2", v, loc)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("Location %s does not exist", loc)
+	}
+	return err
+}
+
+// SetMETAR sets or updates single METAR for a location
+// See Database interface for details.
+func (db *DbPostgres) SetMETAR(loc string, metar string, obsTime time.Time, expire int64) error {
+	return db.SetMETARs([]MetarEntry{{Location: loc, Metar: metar, ObsTime: obsTime, Expire: expire}})
+}
+
+// SetTAF sets or updates single TAF for a location
+// See Database interface for details.
+func (db *DbPostgres) SetTAF(loc string, taf string, validFrom time.Time, validTo time.Time, expire int64) error {
+	return db.SetTAFs([]TafEntry{{Location: loc, Taf: taf, ValidFrom: validFrom, ValidTo: validTo, Expire: expire}})
+}
+
+// SetMETARs sets or updates multiple METARs in a single transaction.
+// See Database interface for details.
+func (db *DbPostgres) SetMETARs(entries []MetarEntry) error {
+	now := time.Now()
+	return sqlInTransaction(db.db,
+		"INSERT INTO metars (location, metar, obs_time, expires_at) VALUES ($1, $2, $3, $4) "+
+			"ON CONFLICT (location) DO UPDATE SET "+
+			"metar = EXCLUDED.metar, obs_time = EXCLUDED.obs_time, expires_at = EXCLUDED.expires_at",
+		len(entries),
+		func(stmt *sql.Stmt, i int) error {
+			e := entries[i]
+			if e.Expire <= 0 {
+				return fmt.Errorf("Invalid expire time %d", e.Expire)
+			}
+			var t string
+			if !e.ObsTime.IsZero() {
+				t = e.ObsTime.UTC().Format(time.RFC3339)
+			}
+			_, err := stmt.Exec(e.Location, e.Metar, t, now.Add(time.Duration(e.Expire)*time.Second))
+			return err
+		})
+}
+
+// SetTAFs sets or updates multiple TAFs in a single transaction.
+// See Database interface for details.
+func (db *DbPostgres) SetTAFs(entries []TafEntry) error {
+	now := time.Now()
+	return sqlInTransaction(db.db,
+		"INSERT INTO tafs (location, taf, valid_from, valid_to, issue_time, expires_at) "+
+			"VALUES ($1, $2, $3, $4, $5, $6) "+
+			"ON CONFLICT (location) DO UPDATE SET taf = EXCLUDED.taf, valid_from = EXCLUDED.valid_from, "+
+			"valid_to = EXCLUDED.valid_to, issue_time = EXCLUDED.issue_time, expires_at = EXCLUDED.expires_at",
+		len(entries),
+		func(stmt *sql.Stmt, i int) error {
+			e := entries[i]
+			if e.Expire <= 0 {
+				return fmt.Errorf("Invalid expire time %d", e.Expire)
+			}
+			_, err := stmt.Exec(e.Location, e.Taf, timeOrEmpty(e.ValidFrom), timeOrEmpty(e.ValidTo),
+				timeOrEmpty(e.IssueTime), now.Add(time.Duration(e.Expire)*time.Second))
+			return err
+		})
+}
+
+// DeleteMETAR deletes METAR for a location
+// See Database interface for details.
+func (db *DbPostgres) DeleteMETAR(loc string) error {
+	_, err := db.db.Exec("DELETE FROM metars WHERE location = $1", loc)
+	return err
+}
+
+// DeleteTAF deletes TAF for a location
+// See Database interface for details.
+func (db *DbPostgres) DeleteTAF(loc string) error {
+	_, err := db.db.Exec("DELETE FROM tafs WHERE location = $1", loc)
+	return err
+}
+
+// CheckIntegrity scans the database for inconsistent data. Location data
+// cannot be corrupt since the columns are typed, so only orphaned reports
+// are reported.
+// See Database interface for details.
+func (db *DbPostgres) CheckIntegrity() (IntegrityReport, error) {
+	var r IntegrityReport
+	if err := db.db.QueryRow("SELECT COUNT(*) FROM locations").Scan(&r.Locations); err != nil {
+		return r, err
+	}
+	var err error
+	r.Metars, r.OrphanedMetars, r.OrphanedMetarSamples, err = db.getOrphaned("metars")
+	if err != nil {
+		return r, err
+	}
+	r.Tafs, r.OrphanedTafs, r.OrphanedTafSamples, err = db.getOrphaned("tafs")
+	return r, err
+}
+
+// RepairOrphaned repairs METARs and TAFs for locations which are not in the
+// database.
+// See Database interface for details.
+func (db *DbPostgres) RepairOrphaned(action RepairAction) (int, error) {
+	var queries []string
+	switch action {
+	case RepairCreatePlaceholders:
+		queries = []string{
+			"INSERT INTO locations (location) SELECT location FROM metars WHERE expires_at > now() " +
+				"UNION SELECT location FROM tafs WHERE expires_at > now() ON CONFLICT DO NOTHING",
+		}
+	case RepairDeleteOrphaned:
+		queries = []string{
+			"DELETE FROM metars WHERE expires_at > now() AND location NOT IN (SELECT location FROM locations)",
+			"DELETE FROM tafs WHERE expires_at > now() AND location NOT IN (SELECT location FROM locations)",
+		}
+	default:
+		return 0, fmt.Errorf("Unknown repair action %d", action)
+	}
+	num := 0
+	for _, q := range queries {
+		res, err := db.db.Exec(q)
+		if err != nil {
+			return num, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return num, err
+		}
+		num += int(n)
+	}
+	return num, nil
+}
+
+// ListLocations retreives a page of ICAO locations. The cursor is the
+// offset of the page in the sorted list of locations.
+// See Database interface for details.
+func (db *DbPostgres) ListLocations(prefix string, cursor uint64, count int) ([]string, uint64, error) {
+	if count <= 0 {
+		count = 10
+	}
+	rows, err := db.db.Query("SELECT location FROM locations WHERE left(location, $1) = $2 "+
+		"ORDER BY location LIMIT $3 OFFSET $4", len(prefix), prefix, count, cursor)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	result := make([]string, 0, count)
+	for rows.Next() {
+		var l string
+		if err := rows.Scan(&l); err != nil {
+			return nil, 0, err
+		}
+		result = append(result, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	if len(result) < count {
+		return result, 0, nil
+	}
+	return result, cursor + uint64(count), nil
+}
+
+// GetNearestLocations retreives location data for the nearest locations.
+// The distance is calculated by PostgreSQL without spatial index.
+// See Database interface for details.
+func (db *DbPostgres) GetNearestLocations(lat float64, lon float64, n int) ([]*wxtypes.DataICAOLocation, error) {
+	if n <= 0 {
+		return make([]*wxtypes.DataICAOLocation, 0), nil
+	}
+	rows, err := db.db.Query("SELECT "+sqlLocationColumns+", "+postgresDistanceKm+" AS distance "+
+		"FROM locations WHERE abs(latitude) <= $3 ORDER BY distance LIMIT $4",
+		lat, lon, GeoMaxLatitude, n)
+	if err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	defer rows.Close()
+	var result []*wxtypes.DataICAOLocation
+	for rows.Next() {
+		var ld wxtypes.DataICAOLocation
+		err := rows.Scan(&ld.Location, &ld.Name, &ld.City, &ld.CountryCode, &ld.Latitude,
+			&ld.Longitude, &ld.AltitudeFeet, &ld.Timezone, &ld.Closed, &ld.Region,
+			&ld.CountryName, &ld.DistanceKm)
+		if err != nil {
+			return make([]*wxtypes.DataICAOLocation, 0), err
+		}
+		ld.AltitudeMeters = altitudeMeters(ld.AltitudeFeet)
+		result = append(result, &ld)
+	}
+	if err := rows.Err(); err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	return result, nil
+}
+
+// GetLocationsInBox retreives location data for the locations within the
+// bounding box.
+// See Database interface for details.
+func (db *DbPostgres) GetLocationsInBox(minLat float64, minLon float64, maxLat float64, maxLon float64) ([]*wxtypes.DataICAOLocation, error) {
+	return querySQLLocations(db.db, "SELECT "+sqlLocationColumns+" FROM locations "+
+		"WHERE latitude BETWEEN $1 AND $2 AND longitude BETWEEN $3 AND $4 AND abs(latitude) <= $5 "+
+		"ORDER BY location", minLat, maxLat, minLon, maxLon, GeoMaxLatitude)
+}
+
+// Ping checks whether PostgreSQL server is reachable.
+// See Database interface for details.
+func (db *DbPostgres) Ping() error {
+	return db.db.Ping()
+}
+
+// GetDataEpoch retreives data epoch.
+// See Database interface for details.
+func (db *DbPostgres) GetDataEpoch() (int64, error) {
+	var epoch int64
+	err := db.db.QueryRow("SELECT epoch FROM epoch WHERE id = 0").Scan(&epoch)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return epoch, err
+}
+
+// IncrementDataEpoch increments data epoch.
+// See Database interface for details.
+func (db *DbPostgres) IncrementDataEpoch() (int64, error) {
+	var epoch int64
+	err := db.db.QueryRow("INSERT INTO epoch (id, epoch) VALUES (0, 1) " +
+		"ON CONFLICT (id) DO UPDATE SET epoch = epoch.epoch + 1 RETURNING epoch").Scan(&epoch)
+	return epoch, err
+}
+
+// GetCounts retreives the number of locations, METARs and TAFs.
+// See Database interface for details.
+func (db *DbPostgres) GetCounts() (int, int, int, error) {
+	var locations, metars, tafs int
+	err := db.db.QueryRow("SELECT "+
+		"(SELECT COUNT(*) FROM locations), "+
+		"(SELECT COUNT(*) FROM metars WHERE expires_at > now()), "+
+		"(SELECT COUNT(*) FROM tafs WHERE expires_at > now())").Scan(&locations, &metars, &tafs)
+	return locations, metars, tafs, err
+}
+
+// GetCountryCounts retreives the number of locations and the number of
+// locations with current METAR for each country.
+// See Database interface for details.
+func (db *DbPostgres) GetCountryCounts() (map[string]CountryCount, error) {
+	result := make(map[string]CountryCount)
+	rows, err := db.db.Query("SELECT l.country_code, COUNT(*), COUNT(m.location) FROM locations l " +
+		"LEFT JOIN metars m ON m.location = l.location AND m.expires_at > now() " +
+		"GROUP BY l.country_code")
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var country string
+		var c CountryCount
+		if err := rows.Scan(&country, &c.Locations, &c.Reporting); err != nil {
+			return result, err
+		}
+		result[country] = c
+	}
+	return result, rows.Err()
+}
+
+func (db *DbPostgres) setLocation(onConflict string, data *wxtypes.DataICAOLocation) error {
+	_, err := db.db.Exec("INSERT INTO locations ("+sqlLocationColumns+") "+
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) "+onConflict,
+		data.Location, data.Name, data.City, data.CountryCode, data.Latitude,
+		data.Longitude, data.AltitudeFeet, data.Timezone, data.Closed, data.Region,
+		data.CountryName)
+	return err
+}
+
+// getLocations retreives location data for the locations found in the
+// database.
+func (db *DbPostgres) getLocations(ctx context.Context, loc []string) (map[string]*wxtypes.DataICAOLocation, error) {
+	result := make(map[string]*wxtypes.DataICAOLocation, len(loc))
+	if len(loc) == 0 {
+		return result, nil
+	}
+	rows, err := db.db.QueryContext(ctx, "SELECT "+sqlLocationColumns+" FROM locations "+
+		"WHERE location IN ("+postgresPlaceholders(1, len(loc))+")", sqlArgs(loc)...)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		ld, err := scanSQLLocation(rows)
+		if err != nil {
+			return result, err
+		}
+		result[ld.Location] = ld
+	}
+	return result, rows.Err()
+}
+
+// getMetars retreives METARs which are not expired, only Location, Metar and
+// MetarObservationTime fields are initialised.
+func (db *DbPostgres) getMetars(ctx context.Context, loc []string) (map[string]*wxtypes.DataICAOLocation, error) {
+	result := make(map[string]*wxtypes.DataICAOLocation, len(loc))
+	if len(loc) == 0 {
+		return result, nil
+	}
+	rows, err := db.db.QueryContext(ctx, "SELECT location, metar, obs_time FROM metars "+
+		"WHERE location IN ("+postgresPlaceholders(1, len(loc))+") AND expires_at > now()", sqlArgs(loc)...)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ld wxtypes.DataICAOLocation
+		if err := rows.Scan(&ld.Location, &ld.Metar, &ld.MetarObservationTime); err != nil {
+			return result, err
+		}
+		result[ld.Location] = &ld
+	}
+	return result, rows.Err()
+}
+
+// getTafs retreives TAFs which are not expired, only Location, Taf,
+// TafValidFrom, TafValidTo and TafIssueTime fields are initialised.
+func (db *DbPostgres) getTafs(ctx context.Context, loc []string) (map[string]*wxtypes.DataICAOLocation, error) {
+	result := make(map[string]*wxtypes.DataICAOLocation, len(loc))
+	if len(loc) == 0 {
+		return result, nil
+	}
+	rows, err := db.db.QueryContext(ctx, "SELECT location, taf, valid_from, valid_to, issue_time FROM tafs "+
+		"WHERE location IN ("+postgresPlaceholders(1, len(loc))+") AND expires_at > now()", sqlArgs(loc)...)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ld wxtypes.DataICAOLocation
+		if err := rows.Scan(&ld.Location, &ld.Taf, &ld.TafValidFrom, &ld.TafValidTo, &ld.TafIssueTime); err != nil {
+			return result, err
+		}
+		result[ld.Location] = &ld
+	}
+	return result, rows.Err()
+}
+
+func (db *DbPostgres) getReportTTL(table string, loc string) (int64, error) {
+	var expiresAt time.Time
+	err := db.db.QueryRow("SELECT expires_at FROM "+table+" WHERE location = $1", loc).Scan(&expiresAt)
+	if err == sql.ErrNoRows {
+		return -2, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return -2, nil
+	}
+	return int64(ttl / time.Second), nil
+}
+
+// getOrphaned returns the number of reports which are not expired, the
+// number of orphaned reports and the samples of orphaned locations.
+func (db *DbPostgres) getOrphaned(table string) (int, int, []string, error) {
+	var num int
+	err := db.db.QueryRow("SELECT COUNT(*) FROM " + table + " WHERE expires_at > now()").Scan(&num)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	rows, err := db.db.Query("SELECT location FROM " + table + " WHERE expires_at > now() " +
+		"AND location NOT IN (SELECT location FROM locations)")
+	if err != nil {
+		return num, 0, nil, err
+	}
+	defer rows.Close()
+	orphaned := 0
+	var samples []string
+	for rows.Next() {
+		var l string
+		if err := rows.Scan(&l); err != nil {
+			return num, orphaned, samples, err
+		}
+		orphaned++
+		samples = appendSample(samples, l)
+	}
+	return num, orphaned, samples, rows.Err()
+}
+
+// sweep deletes expired reports.
+func (db *DbPostgres) sweep() {
+	for _, table := range []string{"metars", "tafs"} {
+		if _, err := db.db.Exec("DELETE FROM " + table + " WHERE expires_at <= now()"); err != nil {
+			logger.Error("Cannot delete expired reports from %s: %s", table, err.Error())
+		}
+	}
+}
+
+// Close stops periodical deletion of expired reports and closes the
+// database.
+// See Database interface for details.
+func (db *DbPostgres) Close() error {
+	db.cancel()
+	<-db.swept
+	return db.db.Close()
+}
+
+// postgresPlaceholders returns n comma-separated numbered placeholders
+// starting from $start.
+func postgresPlaceholders(start int, n int) string {
+	p := make([]string, n)
+	for i := range p {
+		p[i] = fmt.Sprintf("$%d", start+i)
+	}
+	return strings.Join(p, ", ")
+}
+
+// NewDbAccessPostgres is a factory function to create an instance of
+// DbPostgres. Connects to PostgreSQL database specified by dsn, applies the
+// schema migration if the tables are absent and starts periodical deletion
+// of expired reports.
+func NewDbAccessPostgres(dsn string) (Database, error) {
+	pdb, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open PostgreSQL database: %s", err.Error())
+	}
+	pdb.SetMaxOpenConns(postgresMaxOpenConnections)
+	pdb.SetMaxIdleConns(postgresMaxIdleConnections)
+	if err := pdb.Ping(); err != nil {
+		pdb.Close()
+		return nil, fmt.Errorf("Unable to connect to PostgreSQL database: %s", err.Error())
+	}
+	if _, err := pdb.Exec(postgresSchema); err != nil {
+		pdb.Close()
+		return nil, fmt.Errorf("Unable to create PostgreSQL schema: %s", err.Error())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	db := DbPostgres{db: pdb, cancel: cancel}
+	db.swept = util.ScheduleContext(ctx, db.sweep, postgresSweepInterval)
+	return &db, nil
+}
@@ -0,0 +1,106 @@
+/*
+* Copyright (C) 2020 Nick Naumenko (https://gitlab.com/nnaumenko)
+* All rights reserved.
+* This software may be modified and distributed under the terms
+* of the MIT license. See the LICENSE file for details.
+ */
+
+package database
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/nnaumenko/wx/pkg/wxtypes"
+)
+
+// Helpers shared by SQL implementations of Database
+
+// sqlLocationColumns are the columns of location data in SQL databases. The
+// names are the same as LocationField constants.
+const sqlLocationColumns = "location, name, city, country_code, latitude, longitude, " +
+	"altitude_feet, timezone, closed, region, country_name"
+
+// scanSQLLocation scans a row of sqlLocationColumns.
+func scanSQLLocation(rows *sql.Rows) (*wxtypes.DataICAOLocation, error) {
+	var ld wxtypes.DataICAOLocation
+	err := rows.Scan(&ld.Location, &ld.Name, &ld.City, &ld.CountryCode, &ld.Latitude,
+		&ld.Longitude, &ld.AltitudeFeet, &ld.Timezone, &ld.Closed, &ld.Region,
+		&ld.CountryName)
+	ld.AltitudeMeters = altitudeMeters(ld.AltitudeFeet)
+	return &ld, err
+}
+
+// querySQLLocations runs the query which selects sqlLocationColumns and
+// scans the resulting rows.
+func querySQLLocations(db *sql.DB, query string, args ...interface{}) ([]*wxtypes.DataICAOLocation, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	defer rows.Close()
+	result := make([]*wxtypes.DataICAOLocation, 0)
+	for rows.Next() {
+		ld, err := scanSQLLocation(rows)
+		if err != nil {
+			return make([]*wxtypes.DataICAOLocation, 0), err
+		}
+		result = append(result, ld)
+	}
+	if err := rows.Err(); err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	return result, nil
+}
+
+// inOrder returns the data for the locations found in the map in the same
+// order as in loc.
+func inOrder(loc []string, data map[string]*wxtypes.DataICAOLocation) []*wxtypes.DataICAOLocation {
+	var result []*wxtypes.DataICAOLocation
+	for _, l := range loc {
+		if ld, ok := data[l]; ok {
+			result = append(result, ld)
+		}
+	}
+	return result
+}
+
+// sqlPlaceholders returns n comma-separated ? placeholders.
+func sqlPlaceholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// sqlArgs converts the strings to query arguments.
+func sqlArgs(s []string) []interface{} {
+	args := make([]interface{}, len(s))
+	for i := range s {
+		args[i] = s[i]
+	}
+	return args
+}
+
+// sqlInTransaction prepares the query and executes it n times within a single
+// transaction, calling exec for each execution. All executions are
+// performed even if some of them fail; the first error is returned.
+func sqlInTransaction(db *sql.DB, query string, n int, exec func(stmt *sql.Stmt, i int) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	var result error
+	for i := 0; i < n; i++ {
+		if err := exec(stmt, i); err != nil && result == nil {
+			result = err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return result
+}
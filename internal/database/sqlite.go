@@ -0,0 +1,738 @@
+/*
+* Copyright (C) 2020 Nick Naumenko (https://gitlab.com/nnaumenko)
+* All rights reserved.
+* This software may be modified and distributed under the terms
+* of the MIT license. See the LICENSE file for details.
+ */
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nnaumenko/wx/internal/util"
+	"github.com/nnaumenko/wx/pkg/wxtypes"
+
+	// Registers sqlite driver for database/sql
+	_ "modernc.org/sqlite"
+)
+
+const (
+	// sqliteSweepInterval is how often expired reports are deleted
+	sqliteSweepInterval = 1 * time.Minute
+	// sqliteBusyTimeoutMs is how long to wait for a lock held by another
+	// connection or process, such as wx-update writing while wx-server
+	// reads
+	sqliteBusyTimeoutMs = 5000
+)
+
+// sqliteSchema creates the tables unless they already exist. Location
+// columns are named the same as LocationField constants. Expires columns
+// hold Unix time.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS locations (
+	location      TEXT PRIMARY KEY,
+	name          TEXT NOT NULL DEFAULT '',
+	city          TEXT NOT NULL DEFAULT '',
+	country_code  TEXT NOT NULL DEFAULT '',
+	latitude      REAL NOT NULL DEFAULT 0,
+	longitude     REAL NOT NULL DEFAULT 0,
+	altitude_feet INTEGER NOT NULL DEFAULT 0,
+	timezone      TEXT NOT NULL DEFAULT '',
+	closed        INTEGER NOT NULL DEFAULT 0,
+	region        TEXT NOT NULL DEFAULT '',
+	country_name  TEXT NOT NULL DEFAULT ''
+);
+CREATE TABLE IF NOT EXISTS metars (
+	location TEXT PRIMARY KEY,
+	metar    TEXT NOT NULL,
+	obs_time TEXT NOT NULL DEFAULT '',
+	expires  INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS tafs (
+	location   TEXT PRIMARY KEY,
+	taf        TEXT NOT NULL,
+	expires    INTEGER NOT NULL,
+	valid_from TEXT NOT NULL DEFAULT '',
+	valid_to   TEXT NOT NULL DEFAULT '',
+	issue_time TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS metars_expires ON metars (expires);
+CREATE INDEX IF NOT EXISTS tafs_expires ON tafs (expires);
+CREATE TABLE IF NOT EXISTS epoch (
+	id    INTEGER PRIMARY KEY CHECK (id = 0),
+	epoch INTEGER NOT NULL
+);
+`
+
+// sqliteLocationColumnsAdded and sqliteTafColumnsAdded are the columns added
+// to locations and tafs tables after they were first created, along with
+// their definitions. SQLite cannot add a column only if it does not exist, so
+// the existing columns are checked.
+var sqliteLocationColumnsAdded = [][2]string{
+	{"region", "TEXT NOT NULL DEFAULT ''"},
+	{"country_name", "TEXT NOT NULL DEFAULT ''"},
+}
+
+var sqliteTafColumnsAdded = [][2]string{
+	{"valid_from", "TEXT NOT NULL DEFAULT ''"},
+	{"valid_to", "TEXT NOT NULL DEFAULT ''"},
+	{"issue_time", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// sqliteAddColumns adds the columns missing in the table created by older
+// version.
+func sqliteAddColumns(sdb *sql.DB, table string, columns [][2]string) error {
+	rows, err := sdb.Query("SELECT name FROM pragma_table_info('" + table + "')")
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, c := range columns {
+		if existing[c[0]] {
+			continue
+		}
+		if _, err := sdb.Exec("ALTER TABLE " + table + " ADD COLUMN " + c[0] + " " + c[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DbSQLite is an implementation of Database which stores data in SQLite
+// database file. Intended for small self-hosted deployments which do not
+// need Redis. Expired reports are not served and are periodically deleted.
+type DbSQLite struct {
+	db     *sql.DB
+	cancel context.CancelFunc
+	swept  <-chan struct{}
+}
+
+// GetICAOLocationData retreives selected data fields for ICAO locations.
+// See Database interface for details.
+func (db *DbSQLite) GetICAOLocationData(loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	return db.GetICAOLocationDataContext(context.Background(), loc)
+}
+
+// GetICAOLocationDataContext retreives selected data fields for ICAO
+// locations.
+// See Database interface for details.
+func (db *DbSQLite) GetICAOLocationDataContext(ctx context.Context, loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	locs, err := db.getLocations(ctx, loc)
+	if err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	metars, err := db.getMetars(ctx, loc)
+	if err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	tafs, err := db.getTafs(ctx, loc)
+	if err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	var result []*wxtypes.DataICAOLocation
+	for _, l := range loc {
+		if ld, ok := locs[l]; ok {
+			if m, ok := metars[l]; ok {
+				ld.Metar, ld.MetarObservationTime = m.Metar, m.MetarObservationTime
+			}
+			if t, ok := tafs[l]; ok {
+				ld.Taf, ld.TafValidFrom, ld.TafValidTo, ld.TafIssueTime = t.Taf, t.TafValidFrom, t.TafValidTo, t.TafIssueTime
+			}
+			result = append(result, ld)
+		}
+	}
+	return result, nil
+}
+
+// GetLocationInfo retreives only location data for ICAO locations.
+// See Database interface for details.
+func (db *DbSQLite) GetLocationInfo(loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	return db.GetLocationInfoContext(context.Background(), loc)
+}
+
+// GetLocationInfoContext retreives only location data for ICAO locations.
+// See Database interface for details.
+func (db *DbSQLite) GetLocationInfoContext(ctx context.Context, loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	locs, err := db.getLocations(ctx, loc)
+	if err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	return inOrder(loc, locs), nil
+}
+
+// GetMETARs retreives only METAR reports for ICAO locations.
+// See Database interface for details.
+func (db *DbSQLite) GetMETARs(loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	return db.GetMETARsContext(context.Background(), loc)
+}
+
+// GetMETARsContext retreives only METAR reports for ICAO locations.
+// See Database interface for details.
+func (db *DbSQLite) GetMETARsContext(ctx context.Context, loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	metars, err := db.getMetars(ctx, loc)
+	if err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	return inOrder(loc, metars), nil
+}
+
+// GetTAFs retreives only TAF reports for ICAO locations.
+// See Database interface for details.
+func (db *DbSQLite) GetTAFs(loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	return db.GetTAFsContext(context.Background(), loc)
+}
+
+// GetTAFsContext retreives only TAF reports for ICAO locations.
+// See Database interface for details.
+func (db *DbSQLite) GetTAFsContext(ctx context.Context, loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	tafs, err := db.getTafs(ctx, loc)
+	if err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	return inOrder(loc, tafs), nil
+}
+
+// GetMETARsTAFs retreives only METAR and TAF reports for ICAO locations.
+// See Database interface for details.
+func (db *DbSQLite) GetMETARsTAFs(loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	return db.GetMETARsTAFsContext(context.Background(), loc)
+}
+
+// GetMETARsTAFsContext retreives only METAR and TAF reports for ICAO
+// locations.
+// See Database interface for details.
+func (db *DbSQLite) GetMETARsTAFsContext(ctx context.Context, loc []string) ([]*wxtypes.DataICAOLocation, error) {
+	metars, err := db.getMetars(ctx, loc)
+	if err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	tafs, err := db.getTafs(ctx, loc)
+	if err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	var result []*wxtypes.DataICAOLocation
+	for _, l := range loc {
+		m, hasMetar := metars[l]
+		t, hasTaf := tafs[l]
+		switch {
+		case hasMetar && hasTaf:
+			m.Taf, m.TafValidFrom, m.TafValidTo, m.TafIssueTime = t.Taf, t.TafValidFrom, t.TafValidTo, t.TafIssueTime
+			result = append(result, m)
+		case hasMetar:
+			result = append(result, m)
+		case hasTaf:
+			result = append(result, t)
+		}
+	}
+	return result, nil
+}
+
+// GetMETARTTL retreives remaining time-to-expire of METAR for a location.
+// See Database interface for details.
+func (db *DbSQLite) GetMETARTTL(loc string) (int64, error) {
+	return db.getReportTTL("metars", loc)
+}
+
+// GetTAFTTL retreives remaining time-to-expire of TAF for a location.
+// See Database interface for details.
+func (db *DbSQLite) GetTAFTTL(loc string) (int64, error) {
+	return db.getReportTTL("tafs", loc)
+}
+
+// LocationExists checks whether an ICAO location exists in the database.
+// See Database interface for details.
+func (db *DbSQLite) LocationExists(loc string) (bool, error) {
+	var n int
+	err := db.db.QueryRow("SELECT COUNT(*) FROM locations WHERE location = ?", loc).Scan(&n)
+	return n > 0, err
+}
+
+// SetDataICAOLocation sets the location data in the database.
+// See Database interface for details.
+func (db *DbSQLite) SetDataICAOLocation(data *wxtypes.DataICAOLocation) error {
+	return db.setLocation("INSERT OR REPLACE", data)
+}
+
+// SetDataICAOLocationIfAbsent sets the location data in the database unless
+// the location already exists.
+// See Database interface for details.
+func (db *DbSQLite) SetDataICAOLocationIfAbsent(data *wxtypes.DataICAOLocation) error {
+	return db.setLocation("INSERT OR IGNORE", data)
+}
+
+// UpdateLocationField updates a single field of location data.
+// See Database interface for details.
+func (db *DbSQLite) UpdateLocationField(loc string, field string, value string) error {
+	var v interface{}
+	var err error
+	switch field {
+	case LocationFieldName, LocationFieldCity, LocationFieldCountryCode,
+		LocationFieldCountryName, LocationFieldRegion, LocationFieldTimezone:
+		v = value
+	case LocationFieldLatitude, LocationFieldLongitude:
+		v, err = strconv.ParseFloat(value, 64)
+	case LocationFieldAltitudeFeet:
+		v, err = strconv.Atoi(value)
+	case LocationFieldClosed:
+		v, err = strconv.ParseBool(value)
+	default:
+		return fmt.Errorf("Unknown location field %s", field)
+	}
+	if err != nil {
+		return fmt.Errorf("Invalid value %s for location field %s: %s", value, field, err.Error())
+	}
+	// Column names are the same as field names validated above
+	res, err := db.db.Exec("UPDATE locations SET "+field+" = ? WHERE location = ?", v, loc)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("Location %s does not exist", loc)
+	}
+	return err
+}
+
+// SetMETAR sets or updates single METAR for a location
+// See Database interface for details.
+func (db *DbSQLite) SetMETAR(loc string, metar string, obsTime time.Time, expire int64) error {
+	return db.SetMETARs([]MetarEntry{{Location: loc, Metar: metar, ObsTime: obsTime, Expire: expire}})
+}
+
+// SetTAF sets or updates single TAF for a location
+// See Database interface for details.
+func (db *DbSQLite) SetTAF(loc string, taf string, validFrom time.Time, validTo time.Time, expire int64) error {
+	return db.SetTAFs([]TafEntry{{Location: loc, Taf: taf, ValidFrom: validFrom, ValidTo: validTo, Expire: expire}})
+}
+
+// SetMETARs sets or updates multiple METARs in a single transaction.
+// See Database interface for details.
+func (db *DbSQLite) SetMETARs(entries []MetarEntry) error {
+	now := time.Now().Unix()
+	return sqlInTransaction(db.db,
+		"INSERT OR REPLACE INTO metars (location, metar, obs_time, expires) VALUES (?, ?, ?, ?)",
+		len(entries),
+		func(stmt *sql.Stmt, i int) error {
+			e := entries[i]
+			if e.Expire <= 0 {
+				return fmt.Errorf("Invalid expire time %d", e.Expire)
+			}
+			var t string
+			if !e.ObsTime.IsZero() {
+				t = e.ObsTime.UTC().Format(time.RFC3339)
+			}
+			_, err := stmt.Exec(e.Location, e.Metar, t, now+e.Expire)
+			return err
+		})
+}
+
+// SetTAFs sets or updates multiple TAFs in a single transaction.
+// See Database interface for details.
+func (db *DbSQLite) SetTAFs(entries []TafEntry) error {
+	now := time.Now().Unix()
+	return sqlInTransaction(db.db,
+		"INSERT OR REPLACE INTO tafs (location, taf, valid_from, valid_to, issue_time, expires) "+
+			"VALUES (?, ?, ?, ?, ?, ?)",
+		len(entries),
+		func(stmt *sql.Stmt, i int) error {
+			e := entries[i]
+			if e.Expire <= 0 {
+				return fmt.Errorf("Invalid expire time %d", e.Expire)
+			}
+			_, err := stmt.Exec(e.Location, e.Taf, timeOrEmpty(e.ValidFrom), timeOrEmpty(e.ValidTo),
+				timeOrEmpty(e.IssueTime), now+e.Expire)
+			return err
+		})
+}
+
+// DeleteMETAR deletes METAR for a location
+// See Database interface for details.
+func (db *DbSQLite) DeleteMETAR(loc string) error {
+	_, err := db.db.Exec("DELETE FROM metars WHERE location = ?", loc)
+	return err
+}
+
+// DeleteTAF deletes TAF for a location
+// See Database interface for details.
+func (db *DbSQLite) DeleteTAF(loc string) error {
+	_, err := db.db.Exec("DELETE FROM tafs WHERE location = ?", loc)
+	return err
+}
+
+// CheckIntegrity scans the database for inconsistent data. Location data
+// cannot be corrupt since the columns are typed, so only orphaned reports
+// are reported.
+// See Database interface for details.
+func (db *DbSQLite) CheckIntegrity() (IntegrityReport, error) {
+	var r IntegrityReport
+	now := time.Now().Unix()
+	if err := db.db.QueryRow("SELECT COUNT(*) FROM locations").Scan(&r.Locations); err != nil {
+		return r, err
+	}
+	var err error
+	r.Metars, r.OrphanedMetars, r.OrphanedMetarSamples, err = db.getOrphaned("metars", now)
+	if err != nil {
+		return r, err
+	}
+	r.Tafs, r.OrphanedTafs, r.OrphanedTafSamples, err = db.getOrphaned("tafs", now)
+	return r, err
+}
+
+// RepairOrphaned repairs METARs and TAFs for locations which are not in the
+// database.
+// See Database interface for details.
+func (db *DbSQLite) RepairOrphaned(action RepairAction) (int, error) {
+	var queries []string
+	switch action {
+	case RepairCreatePlaceholders:
+		queries = []string{
+			"INSERT OR IGNORE INTO locations (location) SELECT location FROM metars WHERE expires > ?1 " +
+				"UNION SELECT location FROM tafs WHERE expires > ?1",
+		}
+	case RepairDeleteOrphaned:
+		queries = []string{
+			"DELETE FROM metars WHERE expires > ? AND location NOT IN (SELECT location FROM locations)",
+			"DELETE FROM tafs WHERE expires > ? AND location NOT IN (SELECT location FROM locations)",
+		}
+	default:
+		return 0, fmt.Errorf("Unknown repair action %d", action)
+	}
+	now := time.Now().Unix()
+	num := 0
+	for _, q := range queries {
+		res, err := db.db.Exec(q, now)
+		if err != nil {
+			return num, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return num, err
+		}
+		num += int(n)
+	}
+	return num, nil
+}
+
+// ListLocations retreives a page of ICAO locations. The cursor is the
+// offset of the page in the sorted list of locations.
+// See Database interface for details.
+func (db *DbSQLite) ListLocations(prefix string, cursor uint64, count int) ([]string, uint64, error) {
+	if count <= 0 {
+		count = 10
+	}
+	rows, err := db.db.Query("SELECT location FROM locations WHERE substr(location, 1, ?) = ? "+
+		"ORDER BY location LIMIT ? OFFSET ?", len(prefix), prefix, count, cursor)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	result := make([]string, 0, count)
+	for rows.Next() {
+		var l string
+		if err := rows.Scan(&l); err != nil {
+			return nil, 0, err
+		}
+		result = append(result, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	if len(result) < count {
+		return result, 0, nil
+	}
+	return result, cursor + uint64(count), nil
+}
+
+// GetNearestLocations retreives location data for the nearest locations.
+// SQLite has no spatial index, so the distance to every location is
+// calculated.
+// See Database interface for details.
+func (db *DbSQLite) GetNearestLocations(lat float64, lon float64, n int) ([]*wxtypes.DataICAOLocation, error) {
+	rows, err := db.db.Query("SELECT "+sqlLocationColumns+" FROM locations "+
+		"WHERE latitude BETWEEN ? AND ?", -GeoMaxLatitude, GeoMaxLatitude)
+	if err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	defer rows.Close()
+	var result []*wxtypes.DataICAOLocation
+	for rows.Next() {
+		ld, err := scanSQLLocation(rows)
+		if err != nil {
+			return make([]*wxtypes.DataICAOLocation, 0), err
+		}
+		ld.DistanceKm = util.DistanceKm(lat, lon, ld.Latitude, ld.Longitude)
+		result = append(result, ld)
+	}
+	if err := rows.Err(); err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].DistanceKm < result[j].DistanceKm
+	})
+	if n < 0 {
+		n = 0
+	}
+	if len(result) > n {
+		result = result[:n]
+	}
+	return result, nil
+}
+
+// GetLocationsInBox retreives location data for the locations within the
+// bounding box.
+// See Database interface for details.
+func (db *DbSQLite) GetLocationsInBox(minLat float64, minLon float64, maxLat float64, maxLon float64) ([]*wxtypes.DataICAOLocation, error) {
+	return querySQLLocations(db.db, "SELECT "+sqlLocationColumns+" FROM locations "+
+		"WHERE latitude BETWEEN ? AND ? AND longitude BETWEEN ? AND ? AND abs(latitude) <= ? "+
+		"ORDER BY location", minLat, maxLat, minLon, maxLon, GeoMaxLatitude)
+}
+
+// Ping checks whether the database file is accessible.
+// See Database interface for details.
+func (db *DbSQLite) Ping() error {
+	return db.db.Ping()
+}
+
+// GetDataEpoch retreives data epoch.
+// See Database interface for details.
+func (db *DbSQLite) GetDataEpoch() (int64, error) {
+	var epoch int64
+	err := db.db.QueryRow("SELECT epoch FROM epoch WHERE id = 0").Scan(&epoch)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return epoch, err
+}
+
+// IncrementDataEpoch increments data epoch.
+// See Database interface for details.
+func (db *DbSQLite) IncrementDataEpoch() (int64, error) {
+	_, err := db.db.Exec("INSERT INTO epoch (id, epoch) VALUES (0, 1) " +
+		"ON CONFLICT (id) DO UPDATE SET epoch = epoch + 1")
+	if err != nil {
+		return 0, err
+	}
+	return db.GetDataEpoch()
+}
+
+// GetCounts retreives the number of locations, METARs and TAFs.
+// See Database interface for details.
+func (db *DbSQLite) GetCounts() (int, int, int, error) {
+	var locations, metars, tafs int
+	now := time.Now().Unix()
+	err := db.db.QueryRow("SELECT "+
+		"(SELECT COUNT(*) FROM locations), "+
+		"(SELECT COUNT(*) FROM metars WHERE expires > ?), "+
+		"(SELECT COUNT(*) FROM tafs WHERE expires > ?)", now, now).Scan(&locations, &metars, &tafs)
+	return locations, metars, tafs, err
+}
+
+// GetCountryCounts retreives the number of locations and the number of
+// locations with current METAR for each country.
+// See Database interface for details.
+func (db *DbSQLite) GetCountryCounts() (map[string]CountryCount, error) {
+	result := make(map[string]CountryCount)
+	rows, err := db.db.Query("SELECT l.country_code, COUNT(*), COUNT(m.location) FROM locations l "+
+		"LEFT JOIN metars m ON m.location = l.location AND m.expires > ? "+
+		"GROUP BY l.country_code", time.Now().Unix())
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var country string
+		var c CountryCount
+		if err := rows.Scan(&country, &c.Locations, &c.Reporting); err != nil {
+			return result, err
+		}
+		result[country] = c
+	}
+	return result, rows.Err()
+}
+
+func (db *DbSQLite) setLocation(insert string, data *wxtypes.DataICAOLocation) error {
+	_, err := db.db.Exec(insert+" INTO locations ("+sqlLocationColumns+") "+
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		data.Location, data.Name, data.City, data.CountryCode, data.Latitude,
+		data.Longitude, data.AltitudeFeet, data.Timezone, data.Closed, data.Region,
+		data.CountryName)
+	return err
+}
+
+// getLocations retreives location data for the locations found in the
+// database.
+func (db *DbSQLite) getLocations(ctx context.Context, loc []string) (map[string]*wxtypes.DataICAOLocation, error) {
+	result := make(map[string]*wxtypes.DataICAOLocation, len(loc))
+	if len(loc) == 0 {
+		return result, nil
+	}
+	rows, err := db.db.QueryContext(ctx, "SELECT "+sqlLocationColumns+" FROM locations "+
+		"WHERE location IN ("+sqlPlaceholders(len(loc))+")", sqlArgs(loc)...)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		ld, err := scanSQLLocation(rows)
+		if err != nil {
+			return result, err
+		}
+		result[ld.Location] = ld
+	}
+	return result, rows.Err()
+}
+
+// getMetars retreives METARs which are not expired, only Location, Metar and
+// MetarObservationTime fields are initialised.
+func (db *DbSQLite) getMetars(ctx context.Context, loc []string) (map[string]*wxtypes.DataICAOLocation, error) {
+	result := make(map[string]*wxtypes.DataICAOLocation, len(loc))
+	if len(loc) == 0 {
+		return result, nil
+	}
+	args := append(sqlArgs(loc), time.Now().Unix())
+	rows, err := db.db.QueryContext(ctx, "SELECT location, metar, obs_time FROM metars "+
+		"WHERE location IN ("+sqlPlaceholders(len(loc))+") AND expires > ?", args...)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ld wxtypes.DataICAOLocation
+		if err := rows.Scan(&ld.Location, &ld.Metar, &ld.MetarObservationTime); err != nil {
+			return result, err
+		}
+		result[ld.Location] = &ld
+	}
+	return result, rows.Err()
+}
+
+// getTafs retreives TAFs which are not expired, only Location, Taf,
+// TafValidFrom, TafValidTo and TafIssueTime fields are initialised.
+func (db *DbSQLite) getTafs(ctx context.Context, loc []string) (map[string]*wxtypes.DataICAOLocation, error) {
+	result := make(map[string]*wxtypes.DataICAOLocation, len(loc))
+	if len(loc) == 0 {
+		return result, nil
+	}
+	args := append(sqlArgs(loc), time.Now().Unix())
+	rows, err := db.db.QueryContext(ctx, "SELECT location, taf, valid_from, valid_to, issue_time FROM tafs "+
+		"WHERE location IN ("+sqlPlaceholders(len(loc))+") AND expires > ?", args...)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ld wxtypes.DataICAOLocation
+		if err := rows.Scan(&ld.Location, &ld.Taf, &ld.TafValidFrom, &ld.TafValidTo, &ld.TafIssueTime); err != nil {
+			return result, err
+		}
+		result[ld.Location] = &ld
+	}
+	return result, rows.Err()
+}
+
+func (db *DbSQLite) getReportTTL(table string, loc string) (int64, error) {
+	var expires int64
+	err := db.db.QueryRow("SELECT expires FROM "+table+" WHERE location = ?", loc).Scan(&expires)
+	if err == sql.ErrNoRows {
+		return -2, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	ttl := expires - time.Now().Unix()
+	if ttl <= 0 {
+		return -2, nil
+	}
+	return ttl, nil
+}
+
+// getOrphaned returns the number of reports which are not expired, the
+// number of orphaned reports and the samples of orphaned locations.
+func (db *DbSQLite) getOrphaned(table string, now int64) (int, int, []string, error) {
+	var num int
+	err := db.db.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE expires > ?", now).Scan(&num)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	rows, err := db.db.Query("SELECT location FROM "+table+" WHERE expires > ? "+
+		"AND location NOT IN (SELECT location FROM locations)", now)
+	if err != nil {
+		return num, 0, nil, err
+	}
+	defer rows.Close()
+	orphaned := 0
+	var samples []string
+	for rows.Next() {
+		var l string
+		if err := rows.Scan(&l); err != nil {
+			return num, orphaned, samples, err
+		}
+		orphaned++
+		samples = appendSample(samples, l)
+	}
+	return num, orphaned, samples, rows.Err()
+}
+
+// sweep deletes expired reports.
+func (db *DbSQLite) sweep() {
+	now := time.Now().Unix()
+	for _, table := range []string{"metars", "tafs"} {
+		if _, err := db.db.Exec("DELETE FROM "+table+" WHERE expires <= ?", now); err != nil {
+			logger.Error("Cannot delete expired reports from %s: %s", table, err.Error())
+		}
+	}
+}
+
+// Close stops periodical deletion of expired reports and closes the
+// database file.
+// See Database interface for details.
+func (db *DbSQLite) Close() error {
+	db.cancel()
+	<-db.swept
+	return db.db.Close()
+}
+
+// NewDbAccessSQLite is a factory function to create an instance of DbSQLite.
+// Opens SQLite database file at path, creates the schema if absent and
+// starts periodical deletion of expired reports.
+func NewDbAccessSQLite(path string) (Database, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(%d)&_pragma=journal_mode(WAL)",
+		path, sqliteBusyTimeoutMs)
+	sdb, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open SQLite database %s: %s", path, err.Error())
+	}
+	if _, err := sdb.Exec(sqliteSchema); err != nil {
+		sdb.Close()
+		return nil, fmt.Errorf("Unable to create SQLite schema in %s: %s", path, err.Error())
+	}
+	if err := sqliteAddColumns(sdb, "locations", sqliteLocationColumnsAdded); err != nil {
+		sdb.Close()
+		return nil, fmt.Errorf("Unable to migrate SQLite schema in %s: %s", path, err.Error())
+	}
+	if err := sqliteAddColumns(sdb, "tafs", sqliteTafColumnsAdded); err != nil {
+		sdb.Close()
+		return nil, fmt.Errorf("Unable to migrate SQLite schema in %s: %s", path, err.Error())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	db := DbSQLite{db: sdb, cancel: cancel}
+	db.swept = util.ScheduleContext(ctx, db.sweep, sqliteSweepInterval)
+	return &db, nil
+}
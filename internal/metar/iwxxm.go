@@ -0,0 +1,228 @@
+/*
+* Copyright (C) 2020 Nick Naumenko (https://gitlab.com/nnaumenko)
+* All rights reserved.
+* This software may be modified and distributed under the terms
+* of the MIT license. See the LICENSE file for details.
+ */
+
+package metar
+
+const (
+	// Units of measure as used in IWXXM, which follows UCUM notation
+	uomDegrees = "deg"
+	uomKnots   = "[kn_i]"
+	uomMeters  = "m"
+	uomFeet    = "[ft_i]"
+	uomCelsius = "Cel"
+	uomHPa     = "hPa"
+
+	iwxxmOperatorAbove = "ABOVE"
+	iwxxmOperatorBelow = "BELOW"
+
+	// Nil reasons of cloud group as per WMO code list
+	iwxxmNilReasonNoCloud     = "http://codes.wmo.int/common/nil/nothingOfOperationalSignificance"
+	iwxxmNilReasonNotDetected = "http://codes.wmo.int/common/nil/notDetectedByAutoSystem"
+
+	iwxxmWeatherCodeList = "http://codes.wmo.int/306/4678/"
+
+	feetPerMeter = 3.28084
+)
+
+// IWXXMReport is a subset of IWXXM (ICAO Meteorological Information
+// Exchange Model) METAR observation, represented as JSON with the element
+// names of IWXXM and UCUM units. Groups not present in the report are
+// omitted.
+type IWXXMReport struct {
+	ReportType           string            `json:"reportType"`
+	Aerodrome            string            `json:"aerodrome,omitempty"`
+	CloudAndVisibilityOK bool              `json:"cloudAndVisibilityOK"`
+	SurfaceWind          *IWXXMSurfaceWind `json:"surfaceWind,omitempty"`
+	Visibility           *IWXXMVisibility  `json:"visibility,omitempty"`
+	RVR                  []IWXXMRVR        `json:"rvr,omitempty"`
+	PresentWeather       []IWXXMWeather    `json:"presentWeather,omitempty"`
+	Cloud                *IWXXMCloud       `json:"cloud,omitempty"`
+	AirTemperature       *IWXXMMeasure     `json:"airTemperature,omitempty"`
+	DewpointTemperature  *IWXXMMeasure     `json:"dewpointTemperature,omitempty"`
+	QNH                  *IWXXMMeasure     `json:"qnh,omitempty"`
+}
+
+// IWXXMMeasure is a value with unit of measure.
+type IWXXMMeasure struct {
+	Value float64 `json:"value"`
+	UOM   string  `json:"uom"`
+}
+
+// IWXXMSurfaceWind is the surface wind. MeanWindDirection is omitted if the
+// direction is variable.
+type IWXXMSurfaceWind struct {
+	VariableWindDirection bool          `json:"variableWindDirection"`
+	MeanWindDirection     *IWXXMMeasure `json:"meanWindDirection,omitempty"`
+	MeanWindSpeed         IWXXMMeasure  `json:"meanWindSpeed"`
+	WindGustSpeed         *IWXXMMeasure `json:"windGustSpeed,omitempty"`
+}
+
+// IWXXMVisibility is the prevailing visibility in meters. Operator is ABOVE
+// or BELOW if visibility is reported as more than or less than the value.
+type IWXXMVisibility struct {
+	PrevailingVisibility         IWXXMMeasure `json:"prevailingVisibility"`
+	PrevailingVisibilityOperator string       `json:"prevailingVisibilityOperator,omitempty"`
+}
+
+// IWXXMRVR is the runway visual range in meters for a single runway.
+// PastTendency is UPWARD, DOWNWARD or NO_CHANGE if reported.
+type IWXXMRVR struct {
+	Runway          string        `json:"runway"`
+	MeanRVR         *IWXXMMeasure `json:"meanRVR,omitempty"`
+	MeanRVROperator string        `json:"meanRVROperator,omitempty"`
+	PastTendency    string        `json:"pastTendency,omitempty"`
+}
+
+// IWXXMWeather is a present weather group; Href refers to WMO code table
+// 4678.
+type IWXXMWeather struct {
+	Code string `json:"code"`
+	Href string `json:"href"`
+}
+
+// IWXXMCloud holds either cloud layers or vertical visibility. If no cloud
+// is reported (NSC, NCD, SKC or CLR), NilReason holds the reason instead.
+type IWXXMCloud struct {
+	Layer              []IWXXMCloudLayer `json:"layer,omitempty"`
+	VerticalVisibility *IWXXMMeasure     `json:"verticalVisibility,omitempty"`
+	NilReason          string            `json:"nilReason,omitempty"`
+}
+
+// IWXXMCloudLayer is a single cloud layer. Amount is FEW, SCT, BKN or OVC,
+// CloudType is CB or TCU if reported.
+type IWXXMCloudLayer struct {
+	Amount    string        `json:"amount"`
+	Base      *IWXXMMeasure `json:"base,omitempty"`
+	CloudType string        `json:"cloudType,omitempty"`
+}
+
+// IWXXM maps the decoded METAR to the subset of IWXXM. As in IWXXM,
+// visibility, weather and cloud are omitted if CAVOK is reported.
+func (d *DecodedMETAR) IWXXM() IWXXMReport {
+	r := IWXXMReport{
+		ReportType:           d.Type,
+		Aerodrome:            d.Station,
+		CloudAndVisibilityOK: d.CAVOK,
+	}
+	if len(r.ReportType) == 0 {
+		r.ReportType = "METAR"
+	}
+	if d.Wind != nil {
+		r.SurfaceWind = iwxxmSurfaceWind(d.Wind)
+	}
+	if d.Visibility != nil && !d.CAVOK {
+		r.Visibility = &IWXXMVisibility{
+			PrevailingVisibility:         IWXXMMeasure{float64(d.Visibility.Meters), uomMeters},
+			PrevailingVisibilityOperator: iwxxmOperator(d.Visibility.Modifier),
+		}
+	}
+	for _, rvr := range d.RVR {
+		r.RVR = append(r.RVR, iwxxmRVR(rvr))
+	}
+	if !d.CAVOK {
+		for _, w := range d.Weather {
+			r.PresentWeather = append(r.PresentWeather, IWXXMWeather{w, iwxxmWeatherCodeList + w})
+		}
+		if len(d.Clouds) > 0 {
+			r.Cloud = iwxxmCloud(d.Clouds)
+		}
+	}
+	if d.Temperature != nil {
+		r.AirTemperature = &IWXXMMeasure{*d.Temperature, uomCelsius}
+	}
+	if d.Dewpoint != nil {
+		r.DewpointTemperature = &IWXXMMeasure{*d.Dewpoint, uomCelsius}
+	}
+	if d.Altimeter != nil {
+		r.QNH = &IWXXMMeasure{d.Altimeter.HPa, uomHPa}
+	}
+	return r
+}
+
+func iwxxmSurfaceWind(w *Wind) *IWXXMSurfaceWind {
+	uom := uomKnots
+	if w.Unit != UnitKnots {
+		// m/s and km/h are the same in UCUM
+		uom = w.Unit
+	}
+	sw := IWXXMSurfaceWind{
+		VariableWindDirection: w.DirectionDegrees == nil,
+		MeanWindSpeed:         IWXXMMeasure{float64(w.Speed), uom},
+	}
+	if w.DirectionDegrees != nil {
+		sw.MeanWindDirection = &IWXXMMeasure{float64(*w.DirectionDegrees), uomDegrees}
+	}
+	if w.Gust != 0 {
+		sw.WindGustSpeed = &IWXXMMeasure{float64(w.Gust), uom}
+	}
+	return &sw
+}
+
+func iwxxmRVR(rvr RVR) IWXXMRVR {
+	r := IWXXMRVR{Runway: rvr.Runway}
+	if len(rvr.Unit) == 0 {
+		// RVR group was not decoded
+		return r
+	}
+	value := float64(rvr.Value)
+	if rvr.Unit == UnitFeet {
+		value = round(value/feetPerMeter, 0)
+	}
+	r.MeanRVR = &IWXXMMeasure{value, uomMeters}
+	r.MeanRVROperator = iwxxmOperator(rvr.ValueModifier)
+	switch rvr.Trend {
+	case TrendUpward:
+		r.PastTendency = "UPWARD"
+	case TrendDownward:
+		r.PastTendency = "DOWNWARD"
+	case TrendNoChange:
+		r.PastTendency = "NO_CHANGE"
+	}
+	return r
+}
+
+func iwxxmCloud(clouds []Cloud) *IWXXMCloud {
+	var c IWXXMCloud
+	for _, l := range clouds {
+		var base *IWXXMMeasure
+		if l.BaseFeet != nil {
+			base = &IWXXMMeasure{float64(*l.BaseFeet), uomFeet}
+		}
+		switch l.Coverage {
+		case CoverageClear, CoverageNoSignificant:
+			c.NilReason = iwxxmNilReasonNoCloud
+		case CoverageNoneDetected:
+			c.NilReason = iwxxmNilReasonNotDetected
+		case CoverageVerticalVisibility:
+			c.VerticalVisibility = base
+		default:
+			c.Layer = append(c.Layer, IWXXMCloudLayer{
+				Amount:    iwxxmCloudAmount[l.Coverage],
+				Base:      base,
+				CloudType: l.Type,
+			})
+		}
+	}
+	return &c
+}
+
+var iwxxmCloudAmount = map[string]string{
+	CoverageFew:       "FEW",
+	CoverageScattered: "SCT",
+	CoverageBroken:    "BKN",
+	CoverageOvercast:  "OVC",
+}
+
+func iwxxmOperator(modifier string) string {
+	switch modifier {
+	case ModifierMoreThan:
+		return iwxxmOperatorAbove
+	case ModifierLessThan:
+		return iwxxmOperatorBelow
+	}
+	return ""
+}
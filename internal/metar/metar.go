@@ -0,0 +1,582 @@
+/*
+* Copyright (C) 2020 Nick Naumenko (https://gitlab.com/nnaumenko)
+* All rights reserved.
+* This software may be modified and distributed under the terms
+* of the MIT license. See the LICENSE file for details.
+ */
+
+// Package metar decodes raw METAR reports into structured data.
+package metar
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// UnitInHg is inches of mercury
+	UnitInHg string = "inHg"
+	// UnitHPa is hectopascals
+	UnitHPa string = "hPa"
+
+	// UnitKnots is knots
+	UnitKnots string = "kt"
+	// UnitMetersPerSecond is meters per second
+	UnitMetersPerSecond string = "m/s"
+	// UnitKilometersPerHour is kilometers per hour
+	UnitKilometersPerHour string = "km/h"
+
+	// UnitFeet is feet
+	UnitFeet string = "ft"
+	// UnitMeters is meters
+	UnitMeters string = "m"
+	// UnitStatuteMiles is statute miles
+	UnitStatuteMiles string = "mi"
+
+	// ModifierLessThan means the actual value is less than reported
+	ModifierLessThan string = "less_than"
+	// ModifierMoreThan means the actual value is more than reported
+	ModifierMoreThan string = "more_than"
+
+	// TrendUpward means the value is increasing
+	TrendUpward string = "upward"
+	// TrendDownward means the value is decreasing
+	TrendDownward string = "downward"
+	// TrendNoChange means the value does not change
+	TrendNoChange string = "no_change"
+
+	// CoverageFew is 1-2 oktas
+	CoverageFew string = "few"
+	// CoverageScattered is 3-4 oktas
+	CoverageScattered string = "scattered"
+	// CoverageBroken is 5-7 oktas
+	CoverageBroken string = "broken"
+	// CoverageOvercast is 8 oktas
+	CoverageOvercast string = "overcast"
+	// CoverageVerticalVisibility means sky is obscured, vertical visibility
+	// is given instead of the cloud base
+	CoverageVerticalVisibility string = "vertical_visibility"
+	// CoverageClear means clear sky (SKC or CLR)
+	CoverageClear string = "clear"
+	// CoverageNoSignificant means no significant cloud (NSC)
+	CoverageNoSignificant string = "no_significant"
+	// CoverageNoneDetected means no cloud detected by automated station (NCD)
+	CoverageNoneDetected string = "none_detected"
+
+	hPaPerInHg = 33.8639
+
+	metersPerStatuteMile = 1609.344
+)
+
+// DecodedMETAR is the structured data decoded from a raw METAR report.
+// Fields for the groups not present in the report are null.
+// Has JSON tags to be marshalled easily.
+type DecodedMETAR struct {
+	Raw     string `json:"raw"`
+	Type    string `json:"type,omitempty"`
+	Station string `json:"station,omitempty"`
+	Wind    *Wind  `json:"wind"`
+	// Visibility is the prevailing visibility; CAVOK is decoded as
+	// visibility of 10 km or more
+	Visibility *Visibility `json:"visibility"`
+	CAVOK      bool        `json:"cavok,omitempty"`
+	RVR        []RVR       `json:"rvr,omitempty"`
+	// Weather holds present weather groups as reported, such as -RA or
+	// +TSRA
+	Weather     []string `json:"weather,omitempty"`
+	Clouds      []Cloud  `json:"clouds,omitempty"`
+	Temperature *float64 `json:"temperature"`
+	Dewpoint    *float64 `json:"dewpoint"`
+	// TemperaturePrecise is true if temperature and dewpoint with tenths of
+	// degree are taken from T-group in remarks
+	TemperaturePrecise bool       `json:"temperature_precise"`
+	Altimeter          *Altimeter `json:"altimeter"`
+	Unparsed           []string   `json:"unparsed,omitempty"`
+	Remarks            string     `json:"remarks,omitempty"`
+	// Warnings hold the issues found in the decoded data which do not
+	// prevent decoding, such as dewpoint above temperature
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Altimeter is the altimeter setting (QNH). Value and Unit are as reported
+// in the METAR, InHg and HPa hold the value converted to both units.
+type Altimeter struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+	InHg  float64 `json:"inhg"`
+	HPa   float64 `json:"hpa"`
+}
+
+// Wind is the surface wind. DirectionDegrees is null for variable wind
+// direction (VRB). Gust is zero if no gusts are reported.
+type Wind struct {
+	DirectionDegrees *int   `json:"direction_degrees"`
+	Speed            int    `json:"speed"`
+	Gust             int    `json:"gust,omitempty"`
+	Unit             string `json:"unit"`
+}
+
+// Visibility is the prevailing visibility. Value and Unit are as reported in
+// the METAR, Meters holds the value converted to meters. Modifier is set if
+// the visibility is reported as more than (e.g. 9999 or P6SM) or less than
+// (e.g. M1/4SM) the value.
+type Visibility struct {
+	Value    float64 `json:"value"`
+	Unit     string  `json:"unit"`
+	Modifier string  `json:"modifier,omitempty"`
+	Meters   int     `json:"meters"`
+}
+
+// RVR is the runway visual range for a single runway. For variable RVR,
+// MaxValue holds the upper limit of the range. Raw holds the group as
+// reported; if the group cannot be decoded, only Raw and Runway are set.
+type RVR struct {
+	Runway           string `json:"runway"`
+	Value            int    `json:"value,omitempty"`
+	ValueModifier    string `json:"value_modifier,omitempty"`
+	MaxValue         int    `json:"max_value,omitempty"`
+	MaxValueModifier string `json:"max_value_modifier,omitempty"`
+	Unit             string `json:"unit,omitempty"`
+	Trend            string `json:"trend,omitempty"`
+	Raw              string `json:"raw"`
+}
+
+// Cloud is a single cloud layer, or vertical visibility if the sky is
+// obscured. BaseFeet is null if the height is not reported (///). Type is
+// CB or TCU if reported.
+type Cloud struct {
+	Coverage string `json:"coverage"`
+	BaseFeet *int   `json:"base_feet"`
+	Type     string `json:"type,omitempty"`
+}
+
+// groupParser tries to decode a single group of the METAR body. Returns
+// false if the group is not recognised by the parser.
+type groupParser func(d *DecodedMETAR, group string) bool
+
+var bodyParsers = []groupParser{
+	parseWind,
+	parseVisibility,
+	parseRVR,
+	parseCloud,
+	parseWeather,
+	parseTemperature,
+	parseAltimeter,
+}
+
+// remarkParsers decode the remark groups; remarks are preserved verbatim
+// whether decoded or not
+var remarkParsers = []groupParser{
+	parsePreciseTemperature,
+}
+
+// DecodeMETAR decodes raw METAR report. The report may begin with METAR or
+// SPECI report type. The groups which are not recognised are preserved in
+// Unparsed field of DecodedMETAR. The remarks are preserved in Remarks
+// field, and only selected remark groups are decoded.
+func DecodeMETAR(raw string) (DecodedMETAR, error) {
+	d := DecodedMETAR{Raw: raw}
+	groups := strings.Fields(raw)
+	if len(groups) > 0 && (groups[0] == "METAR" || groups[0] == "SPECI") {
+		d.Type = groups[0]
+		groups = groups[1:]
+	}
+	if len(groups) < 1 {
+		return d, errors.New("METAR report is empty")
+	}
+	d.Station = groups[0]
+	groups = joinVisibilityGroups(groups)
+	for i, g := range groups[1:] {
+		if g == "RMK" {
+			d.Remarks = strings.Join(groups[i+2:], " ")
+			for _, r := range groups[i+2:] {
+				for _, p := range remarkParsers {
+					if p(&d, r) {
+						break
+					}
+				}
+			}
+			break
+		}
+		parsed := false
+		for _, p := range bodyParsers {
+			if p(&d, g) {
+				parsed = true
+				break
+			}
+		}
+		if !parsed {
+			d.Unparsed = append(d.Unparsed, g)
+		}
+	}
+	validate(&d)
+	return d, nil
+}
+
+const (
+	maxWindSpeedKnots = 200
+	minAltimeterHPa   = 870
+	maxAltimeterHPa   = 1085
+	knotsPerMPS       = 1.94384
+	knotsPerKMH       = 0.539957
+)
+
+// validate checks the decoded data for values which are syntactically valid
+// but unlikely and adds warnings for them.
+func validate(d *DecodedMETAR) {
+	if d.Temperature != nil && d.Dewpoint != nil && *d.Dewpoint > *d.Temperature {
+		d.Warnings = append(d.Warnings, fmt.Sprintf(
+			"Dewpoint %.1f is above temperature %.1f", *d.Dewpoint, *d.Temperature))
+	}
+	if d.Wind != nil {
+		if d.Wind.DirectionDegrees != nil && *d.Wind.DirectionDegrees > 360 {
+			d.Warnings = append(d.Warnings, fmt.Sprintf(
+				"Wind direction %d is above 360 degrees", *d.Wind.DirectionDegrees))
+		}
+		if windSpeedKnots(d.Wind.Speed, d.Wind.Unit) > maxWindSpeedKnots {
+			d.Warnings = append(d.Warnings, fmt.Sprintf(
+				"Wind speed %d %s is too high", d.Wind.Speed, d.Wind.Unit))
+		}
+		if d.Wind.Gust != 0 && d.Wind.Gust <= d.Wind.Speed {
+			d.Warnings = append(d.Warnings, fmt.Sprintf(
+				"Wind gust %d is not above wind speed %d", d.Wind.Gust, d.Wind.Speed))
+		}
+	}
+	if d.Altimeter != nil && (d.Altimeter.HPa < minAltimeterHPa || d.Altimeter.HPa > maxAltimeterHPa) {
+		d.Warnings = append(d.Warnings, fmt.Sprintf(
+			"Altimeter setting %v %s is out of range", d.Altimeter.Value, d.Altimeter.Unit))
+	}
+}
+
+func windSpeedKnots(speed int, unit string) float64 {
+	switch unit {
+	case UnitMetersPerSecond:
+		return float64(speed) * knotsPerMPS
+	case UnitKilometersPerHour:
+		return float64(speed) * knotsPerKMH
+	}
+	return float64(speed)
+}
+
+var (
+	visibilityMetersRegexp = regexp.MustCompile(`^(\d{4})(NDV)?$`)
+	visibilityMilesRegexp  = regexp.MustCompile(`^([PM]?)(\d{1,2}|\d/\d{1,2}|\d \d/\d{1,2})SM$`)
+	visibilityWholeRegexp  = regexp.MustCompile(`^\d$`)
+	visibilityFracRegexp   = regexp.MustCompile(`^\d/\d{1,2}SM$`)
+)
+
+// joinVisibilityGroups joins visibility in statute miles reported as whole
+// and fraction in separate groups, such as 1 1/2SM, into a single group.
+func joinVisibilityGroups(groups []string) []string {
+	result := make([]string, 0, len(groups))
+	for i := 0; i < len(groups); i++ {
+		if i+1 < len(groups) && visibilityWholeRegexp.MatchString(groups[i]) &&
+			visibilityFracRegexp.MatchString(groups[i+1]) {
+			result = append(result, groups[i]+" "+groups[i+1])
+			i++
+			continue
+		}
+		result = append(result, groups[i])
+	}
+	return result
+}
+
+// parseVisibility decodes prevailing visibility groups in meters such as
+// 0800 or 9999, in statute miles such as 10SM, 1 1/2SM or M1/4SM, and CAVOK.
+func parseVisibility(d *DecodedMETAR, group string) bool {
+	if d.Visibility != nil {
+		// Directional or minimum visibility follows the prevailing one
+		return false
+	}
+	if group == "CAVOK" {
+		d.Visibility = &Visibility{Value: 10000, Unit: UnitMeters, Modifier: ModifierMoreThan, Meters: 10000}
+		d.CAVOK = true
+		return true
+	}
+	if m := visibilityMetersRegexp.FindStringSubmatch(group); m != nil {
+		v, _ := strconv.Atoi(m[1])
+		vis := Visibility{Value: float64(v), Unit: UnitMeters, Meters: v}
+		if v == 9999 {
+			// 9999 means 10 km or more
+			vis = Visibility{Value: 10000, Unit: UnitMeters, Modifier: ModifierMoreThan, Meters: 10000}
+		}
+		d.Visibility = &vis
+		return true
+	}
+	m := visibilityMilesRegexp.FindStringSubmatch(group)
+	if m == nil {
+		return false
+	}
+	var miles float64
+	for _, p := range strings.Fields(m[2]) {
+		if f := strings.Split(p, "/"); len(f) == 2 {
+			num, _ := strconv.Atoi(f[0])
+			den, _ := strconv.Atoi(f[1])
+			if den == 0 {
+				return false
+			}
+			miles += float64(num) / float64(den)
+			continue
+		}
+		v, _ := strconv.Atoi(p)
+		miles += float64(v)
+	}
+	d.Visibility = &Visibility{
+		Value:    round(miles, 3),
+		Unit:     UnitStatuteMiles,
+		Modifier: rvrModifier(m[1]),
+		Meters:   int(math.Round(miles * metersPerStatuteMile)),
+	}
+	return true
+}
+
+var cloudRegexp = regexp.MustCompile(`^(FEW|SCT|BKN|OVC|VV)(\d{3}|///)(CB|TCU|///)?$`)
+
+// parseCloud decodes cloud groups such as FEW020, SCT040CB, BKN///,
+// vertical visibility groups such as VV002 and clear sky groups.
+func parseCloud(d *DecodedMETAR, group string) bool {
+	switch group {
+	case "SKC", "CLR":
+		d.Clouds = append(d.Clouds, Cloud{Coverage: CoverageClear})
+		return true
+	case "NSC":
+		d.Clouds = append(d.Clouds, Cloud{Coverage: CoverageNoSignificant})
+		return true
+	case "NCD":
+		d.Clouds = append(d.Clouds, Cloud{Coverage: CoverageNoneDetected})
+		return true
+	}
+	m := cloudRegexp.FindStringSubmatch(group)
+	if m == nil {
+		return false
+	}
+	var c Cloud
+	switch m[1] {
+	case "FEW":
+		c.Coverage = CoverageFew
+	case "SCT":
+		c.Coverage = CoverageScattered
+	case "BKN":
+		c.Coverage = CoverageBroken
+	case "OVC":
+		c.Coverage = CoverageOvercast
+	case "VV":
+		c.Coverage = CoverageVerticalVisibility
+		if len(m[3]) > 0 {
+			return false
+		}
+	}
+	if h, ok := parseDigits(m[2]); ok {
+		h *= 100 // height is reported in hundreds of feet
+		c.BaseFeet = &h
+	}
+	if m[3] != "///" {
+		c.Type = m[3]
+	}
+	d.Clouds = append(d.Clouds, c)
+	return true
+}
+
+var weatherRegexp = regexp.MustCompile(
+	`^([-+]|VC)?(MI|BC|PR|DR|BL|SH|TS|FZ)?((?:DZ|RA|SN|SG|IC|PL|GR|GS|UP|BR|FG|FU|VA|DU|SA|HZ|PY|PO|SQ|FC|SS|DS){0,3})$`)
+
+// parseWeather recognises present weather groups such as -RA, +TSRA, VCSH
+// or FZFG, which are preserved as reported.
+func parseWeather(d *DecodedMETAR, group string) bool {
+	m := weatherRegexp.FindStringSubmatch(group)
+	if m == nil || (len(m[2]) == 0 && len(m[3]) == 0) {
+		return false
+	}
+	d.Weather = append(d.Weather, group)
+	return true
+}
+
+var temperatureRegexp = regexp.MustCompile(`^(M?\d{2})/(M?\d{2})?$`)
+
+// parseTemperature decodes temperature and dewpoint group such as 25/18 or
+// M02/M05, in degrees Celsius. Dewpoint may be omitted.
+func parseTemperature(d *DecodedMETAR, group string) bool {
+	m := temperatureRegexp.FindStringSubmatch(group)
+	if m == nil {
+		return false
+	}
+	t := parseTemperatureValue(m[1])
+	d.Temperature = &t
+	if len(m[2]) > 0 {
+		dp := parseTemperatureValue(m[2])
+		d.Dewpoint = &dp
+	}
+	return true
+}
+
+// parseTemperatureValue parses temperature value in whole degrees where M
+// designates negative value.
+func parseTemperatureValue(s string) float64 {
+	sign := 1.0
+	if s[0] == 'M' {
+		sign = -1.0
+		s = s[1:]
+	}
+	v, _ := strconv.Atoi(s)
+	return sign * float64(v)
+}
+
+var preciseTemperatureRegexp = regexp.MustCompile(`^T([01]\d{3})([01]\d{3})?$`)
+
+// parsePreciseTemperature decodes T-group in remarks such as T02560211 which
+// specifies temperature and dewpoint in tenths of degree Celsius. First
+// digit of each value is sign: 0 for positive and 1 for negative. The values
+// override whole degree values from the METAR body.
+func parsePreciseTemperature(d *DecodedMETAR, group string) bool {
+	m := preciseTemperatureRegexp.FindStringSubmatch(group)
+	if m == nil {
+		return false
+	}
+	t := parsePreciseTemperatureValue(m[1])
+	d.Temperature = &t
+	if len(m[2]) > 0 {
+		dp := parsePreciseTemperatureValue(m[2])
+		d.Dewpoint = &dp
+	}
+	d.TemperaturePrecise = true
+	return true
+}
+
+func parsePreciseTemperatureValue(s string) float64 {
+	v, _ := strconv.Atoi(s[1:])
+	if s[0] == '1' {
+		v = -v
+	}
+	return float64(v) / 10
+}
+
+// parseAltimeter decodes Axxxx (inches of mercury) and Qxxxx (hectopascal)
+// groups.
+func parseAltimeter(d *DecodedMETAR, group string) bool {
+	if len(group) != 5 || (group[0] != 'A' && group[0] != 'Q') {
+		return false
+	}
+	v, ok := parseDigits(group[1:])
+	if !ok {
+		return false
+	}
+	if group[0] == 'A' {
+		inHg := float64(v) / 100
+		d.Altimeter = &Altimeter{
+			Value: inHg,
+			Unit:  UnitInHg,
+			InHg:  inHg,
+			HPa:   round(inHg*hPaPerInHg, 1),
+		}
+		return true
+	}
+	hPa := float64(v)
+	d.Altimeter = &Altimeter{
+		Value: hPa,
+		Unit:  UnitHPa,
+		InHg:  round(hPa/hPaPerInHg, 2),
+		HPa:   hPa,
+	}
+	return true
+}
+
+var windRegexp = regexp.MustCompile(`^(\d{3}|VRB)(\d{2,3})(?:G(\d{2,3}))?(KT|MPS|KMH)$`)
+
+// parseWind decodes surface wind groups such as 25010KT, 18015G25MPS or
+// VRB03KT.
+func parseWind(d *DecodedMETAR, group string) bool {
+	m := windRegexp.FindStringSubmatch(group)
+	if m == nil {
+		return false
+	}
+	w := Wind{}
+	if m[1] != "VRB" {
+		dir, _ := strconv.Atoi(m[1])
+		w.DirectionDegrees = &dir
+	}
+	w.Speed, _ = strconv.Atoi(m[2])
+	if len(m[3]) > 0 {
+		w.Gust, _ = strconv.Atoi(m[3])
+	}
+	switch m[4] {
+	case "KT":
+		w.Unit = UnitKnots
+	case "MPS":
+		w.Unit = UnitMetersPerSecond
+	case "KMH":
+		w.Unit = UnitKilometersPerHour
+	}
+	d.Wind = &w
+	return true
+}
+
+var (
+	rvrRunwayRegexp = regexp.MustCompile(`^R(\d{2}[LCR]?)/`)
+	rvrRegexp       = regexp.MustCompile(
+		`^R(\d{2}[LCR]?)/([PM]?)(\d{4})(?:V([PM]?)(\d{4}))?(FT)?/?([UDN]?)$`)
+)
+
+// parseRVR decodes runway visual range groups such as R27L/0600FT,
+// R27L/0600V1200FT or R09/P2000U.
+func parseRVR(d *DecodedMETAR, group string) bool {
+	rwy := rvrRunwayRegexp.FindStringSubmatch(group)
+	if rwy == nil {
+		return false
+	}
+	r := RVR{Runway: rwy[1], Raw: group}
+	if m := rvrRegexp.FindStringSubmatch(group); m != nil {
+		r.Value, _ = strconv.Atoi(m[3])
+		r.ValueModifier = rvrModifier(m[2])
+		if len(m[5]) > 0 {
+			r.MaxValue, _ = strconv.Atoi(m[5])
+			r.MaxValueModifier = rvrModifier(m[4])
+		}
+		r.Unit = UnitMeters
+		if m[6] == "FT" {
+			r.Unit = UnitFeet
+		}
+		switch m[7] {
+		case "U":
+			r.Trend = TrendUpward
+		case "D":
+			r.Trend = TrendDownward
+		case "N":
+			r.Trend = TrendNoChange
+		}
+	}
+	d.RVR = append(d.RVR, r)
+	return true
+}
+
+func rvrModifier(m string) string {
+	switch m {
+	case "P":
+		return ModifierMoreThan
+	case "M":
+		return ModifierLessThan
+	}
+	return ""
+}
+
+// parseDigits parses a string which consists of decimal digits only.
+func parseDigits(s string) (int, bool) {
+	if len(s) < 1 {
+		return 0, false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, false
+		}
+	}
+	v, err := strconv.Atoi(s)
+	return v, err == nil
+}
+
+func round(v float64, decimals int) float64 {
+	p := math.Pow(10, float64(decimals))
+	return math.Round(v*p) / p
+}
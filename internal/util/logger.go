@@ -0,0 +1,55 @@
+/*
+* Copyright (C) 2020 Nick Naumenko (https://gitlab.com/nnaumenko)
+* All rights reserved.
+* This software may be modified and distributed under the terms
+* of the MIT license. See the LICENSE file for details.
+ */
+
+package util
+
+import (
+	"log"
+)
+
+// Logger is a leveled logger which may be injected into server, updater and
+// database to use structured logging. The arguments are handled the same
+// way as in fmt.Printf.
+type Logger interface {
+	Debug(format string, v ...interface{})
+	Info(format string, v ...interface{})
+	Warn(format string, v ...interface{})
+	Error(format string, v ...interface{})
+}
+
+// StdLogger is a Logger which writes to the standard logger of log package.
+// Info and Error messages are written as is, Debug and Warn messages are
+// prefixed with the level.
+type StdLogger struct{}
+
+// Debug writes debug message
+func (StdLogger) Debug(format string, v ...interface{}) {
+	log.Printf("DEBUG: "+format, v...)
+}
+
+// Info writes informational message
+func (StdLogger) Info(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+// Warn writes warning message
+func (StdLogger) Warn(format string, v ...interface{}) {
+	log.Printf("WARNING: "+format, v...)
+}
+
+// Error writes error message
+func (StdLogger) Error(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+// LoggerOrDefault returns l, or StdLogger if l is nil.
+func LoggerOrDefault(l Logger) Logger {
+	if l == nil {
+		return StdLogger{}
+	}
+	return l
+}
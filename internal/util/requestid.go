@@ -0,0 +1,71 @@
+/*
+* Copyright (C) 2020 Nick Naumenko (https://gitlab.com/nnaumenko)
+* All rights reserved.
+* This software may be modified and distributed under the terms
+* of the MIT license. See the LICENSE file for details.
+ */
+
+package util
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+)
+
+// RequestIDMaxLength is the maximum length of request ID accepted from the
+// client
+const RequestIDMaxLength = 128
+
+type requestIDKey struct{}
+
+// NewRequestID generates random request ID in UUID version 4 format.
+func NewRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// ValidateRequestID checks whether request ID received from the client is
+// not empty, not too long and consists only of letters, digits and
+// characters - _ . :
+func ValidateRequestID(id string) bool {
+	if len(id) == 0 || len(id) > RequestIDMaxLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ContextWithRequestID returns a copy of ctx which carries request ID.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns request ID carried by ctx, or empty string if there is
+// none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestIDPrefix returns request ID carried by ctx formatted to prefix log
+// messages, or empty string if there is none.
+func RequestIDPrefix(ctx context.Context) string {
+	id := RequestID(ctx)
+	if len(id) == 0 {
+		return ""
+	}
+	return "[" + id + "] "
+}
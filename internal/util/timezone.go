@@ -0,0 +1,199 @@
+/*
+* Copyright (C) 2020 Nick Naumenko (https://gitlab.com/nnaumenko)
+* All rights reserved.
+* This software may be modified and distributed under the terms
+* of the MIT license. See the LICENSE file for details.
+ */
+
+package util
+
+// countryTimezones maps ISO 3166-1 country codes to IANA timezones. Countries
+// spanning several timezones are either omitted, or mapped to the timezone
+// of most of the country and listed in regionTimezones for the rest.
+var countryTimezones = map[string]string{
+	"AD": "Europe/Andorra", "AE": "Asia/Dubai", "AF": "Asia/Kabul", "AG": "America/Antigua",
+	"AI": "America/Anguilla", "AL": "Europe/Tirane", "AM": "Asia/Yerevan", "AO": "Africa/Luanda",
+	"AR": "America/Argentina/Buenos_Aires", "AS": "Pacific/Pago_Pago", "AT": "Europe/Vienna",
+	"AW": "America/Aruba", "AX": "Europe/Mariehamn", "AZ": "Asia/Baku",
+	"BA": "Europe/Sarajevo", "BB": "America/Barbados", "BD": "Asia/Dhaka", "BE": "Europe/Brussels",
+	"BF": "Africa/Ouagadougou", "BG": "Europe/Sofia", "BH": "Asia/Bahrain", "BI": "Africa/Bujumbura",
+	"BJ": "Africa/Porto-Novo", "BL": "America/St_Barthelemy", "BM": "Atlantic/Bermuda",
+	"BN": "Asia/Brunei", "BO": "America/La_Paz", "BQ": "America/Kralendijk", "BR": "America/Sao_Paulo",
+	"BS": "America/Nassau", "BT": "Asia/Thimphu", "BW": "Africa/Gaborone", "BY": "Europe/Minsk",
+	"BZ": "America/Belize",
+	"CC": "Indian/Cocos", "CD": "Africa/Lubumbashi", "CF": "Africa/Bangui", "CG": "Africa/Brazzaville",
+	"CH": "Europe/Zurich", "CI": "Africa/Abidjan", "CK": "Pacific/Rarotonga", "CL": "America/Santiago",
+	"CM": "Africa/Douala", "CN": "Asia/Shanghai", "CO": "America/Bogota", "CR": "America/Costa_Rica",
+	"CU": "America/Havana", "CV": "Atlantic/Cape_Verde", "CW": "America/Curacao",
+	"CX": "Indian/Christmas", "CY": "Asia/Nicosia", "CZ": "Europe/Prague",
+	"DE": "Europe/Berlin", "DJ": "Africa/Djibouti", "DK": "Europe/Copenhagen", "DM": "America/Dominica",
+	"DO": "America/Santo_Domingo", "DZ": "Africa/Algiers",
+	"EC": "America/Guayaquil", "EE": "Europe/Tallinn", "EG": "Africa/Cairo", "EH": "Africa/El_Aaiun",
+	"ER": "Africa/Asmara", "ES": "Europe/Madrid", "ET": "Africa/Addis_Ababa",
+	"FI": "Europe/Helsinki", "FJ": "Pacific/Fiji", "FK": "Atlantic/Stanley", "FM": "Pacific/Pohnpei",
+	"FO": "Atlantic/Faroe", "FR": "Europe/Paris",
+	"GA": "Africa/Libreville", "GB": "Europe/London", "GD": "America/Grenada", "GE": "Asia/Tbilisi",
+	"GF": "America/Cayenne", "GG": "Europe/Guernsey", "GH": "Africa/Accra", "GI": "Europe/Gibraltar",
+	"GL": "America/Nuuk", "GM": "Africa/Banjul", "GN": "Africa/Conakry", "GP": "America/Guadeloupe",
+	"GQ": "Africa/Malabo", "GR": "Europe/Athens", "GT": "America/Guatemala", "GU": "Pacific/Guam",
+	"GW": "Africa/Bissau", "GY": "America/Guyana",
+	"HK": "Asia/Hong_Kong", "HN": "America/Tegucigalpa", "HR": "Europe/Zagreb",
+	"HT": "America/Port-au-Prince", "HU": "Europe/Budapest",
+	"ID": "Asia/Jakarta", "IE": "Europe/Dublin", "IL": "Asia/Jerusalem", "IM": "Europe/Isle_of_Man",
+	"IN": "Asia/Kolkata", "IO": "Indian/Chagos", "IQ": "Asia/Baghdad", "IR": "Asia/Tehran",
+	"IS": "Atlantic/Reykjavik", "IT": "Europe/Rome",
+	"JE": "Europe/Jersey", "JM": "America/Jamaica", "JO": "Asia/Amman", "JP": "Asia/Tokyo",
+	"KE": "Africa/Nairobi", "KG": "Asia/Bishkek", "KH": "Asia/Phnom_Penh", "KI": "Pacific/Tarawa",
+	"KM": "Indian/Comoro", "KN": "America/St_Kitts", "KP": "Asia/Pyongyang", "KR": "Asia/Seoul",
+	"KW": "Asia/Kuwait", "KY": "America/Cayman", "KZ": "Asia/Almaty",
+	"LA": "Asia/Vientiane", "LB": "Asia/Beirut", "LC": "America/St_Lucia", "LI": "Europe/Vaduz",
+	"LK": "Asia/Colombo", "LR": "Africa/Monrovia", "LS": "Africa/Maseru", "LT": "Europe/Vilnius",
+	"LU": "Europe/Luxembourg", "LV": "Europe/Riga", "LY": "Africa/Tripoli",
+	"MA": "Africa/Casablanca", "MC": "Europe/Monaco", "MD": "Europe/Chisinau", "ME": "Europe/Podgorica",
+	"MF": "America/Marigot", "MG": "Indian/Antananarivo", "MH": "Pacific/Majuro", "MK": "Europe/Skopje",
+	"ML": "Africa/Bamako", "MM": "Asia/Yangon", "MN": "Asia/Ulaanbaatar", "MO": "Asia/Macau",
+	"MP": "Pacific/Saipan", "MQ": "America/Martinique", "MR": "Africa/Nouakchott",
+	"MS": "America/Montserrat", "MT": "Europe/Malta", "MU": "Indian/Mauritius", "MV": "Indian/Maldives",
+	"MW": "Africa/Blantyre", "MX": "America/Mexico_City", "MY": "Asia/Kuala_Lumpur", "MZ": "Africa/Maputo",
+	"NA": "Africa/Windhoek", "NC": "Pacific/Noumea", "NE": "Africa/Niamey", "NF": "Pacific/Norfolk",
+	"NG": "Africa/Lagos", "NI": "America/Managua", "NL": "Europe/Amsterdam", "NO": "Europe/Oslo",
+	"NP": "Asia/Kathmandu", "NR": "Pacific/Nauru", "NU": "Pacific/Niue", "NZ": "Pacific/Auckland",
+	"OM": "Asia/Muscat",
+	"PA": "America/Panama", "PE": "America/Lima", "PF": "Pacific/Tahiti", "PG": "Pacific/Port_Moresby",
+	"PH": "Asia/Manila", "PK": "Asia/Karachi", "PL": "Europe/Warsaw", "PM": "America/Miquelon",
+	"PN": "Pacific/Pitcairn", "PR": "America/Puerto_Rico", "PS": "Asia/Gaza", "PT": "Europe/Lisbon",
+	"PW": "Pacific/Palau", "PY": "America/Asuncion",
+	"QA": "Asia/Qatar",
+	"RE": "Indian/Reunion", "RO": "Europe/Bucharest", "RS": "Europe/Belgrade", "RW": "Africa/Kigali",
+	"SA": "Asia/Riyadh", "SB": "Pacific/Guadalcanal", "SC": "Indian/Mahe", "SD": "Africa/Khartoum",
+	"SE": "Europe/Stockholm", "SG": "Asia/Singapore", "SH": "Atlantic/St_Helena",
+	"SI": "Europe/Ljubljana", "SJ": "Arctic/Longyearbyen", "SK": "Europe/Bratislava",
+	"SL": "Africa/Freetown", "SM": "Europe/San_Marino", "SN": "Africa/Dakar", "SO": "Africa/Mogadishu",
+	"SR": "America/Paramaribo", "SS": "Africa/Juba", "ST": "Africa/Sao_Tome",
+	"SV": "America/El_Salvador", "SX": "America/Lower_Princes", "SY": "Asia/Damascus",
+	"SZ": "Africa/Mbabane",
+	"TC": "America/Grand_Turk", "TD": "Africa/Ndjamena", "TF": "Indian/Kerguelen", "TG": "Africa/Lome",
+	"TH": "Asia/Bangkok", "TJ": "Asia/Dushanbe", "TK": "Pacific/Fakaofo", "TL": "Asia/Dili",
+	"TM": "Asia/Ashgabat", "TN": "Africa/Tunis", "TO": "Pacific/Tongatapu", "TR": "Europe/Istanbul",
+	"TT": "America/Port_of_Spain", "TV": "Pacific/Funafuti", "TW": "Asia/Taipei",
+	"TZ": "Africa/Dar_es_Salaam",
+	"UA": "Europe/Kiev", "UG": "Africa/Kampala", "UY": "America/Montevideo", "UZ": "Asia/Tashkent",
+	"VA": "Europe/Vatican", "VC": "America/St_Vincent", "VE": "America/Caracas", "VG": "America/Tortola",
+	"VI": "America/St_Thomas", "VN": "Asia/Ho_Chi_Minh", "VU": "Pacific/Efate",
+	"WF": "Pacific/Wallis", "WS": "Pacific/Apia",
+	"XK": "Europe/Belgrade",
+	"YE": "Asia/Aden", "YT": "Indian/Mayotte",
+	"ZA": "Africa/Johannesburg", "ZM": "Africa/Lusaka", "ZW": "Africa/Harare",
+}
+
+// regionTimezones maps ISO 3166-2 region codes to IANA timezones for the
+// countries spanning several timezones. Regions spanning several timezones
+// are mapped to the timezone of most of the region.
+var regionTimezones = map[string]string{
+	// United States
+	"US-AK": "America/Anchorage", "US-AL": "America/Chicago", "US-AR": "America/Chicago",
+	"US-AZ": "America/Phoenix", "US-CA": "America/Los_Angeles", "US-CO": "America/Denver",
+	"US-CT": "America/New_York", "US-DC": "America/New_York", "US-DE": "America/New_York",
+	"US-FL": "America/New_York", "US-GA": "America/New_York", "US-HI": "Pacific/Honolulu",
+	"US-IA": "America/Chicago", "US-ID": "America/Boise", "US-IL": "America/Chicago",
+	"US-IN": "America/Indiana/Indianapolis", "US-KS": "America/Chicago", "US-KY": "America/New_York",
+	"US-LA": "America/Chicago", "US-MA": "America/New_York", "US-MD": "America/New_York",
+	"US-ME": "America/New_York", "US-MI": "America/Detroit", "US-MN": "America/Chicago",
+	"US-MO": "America/Chicago", "US-MS": "America/Chicago", "US-MT": "America/Denver",
+	"US-NC": "America/New_York", "US-ND": "America/Chicago", "US-NE": "America/Chicago",
+	"US-NH": "America/New_York", "US-NJ": "America/New_York", "US-NM": "America/Denver",
+	"US-NV": "America/Los_Angeles", "US-NY": "America/New_York", "US-OH": "America/New_York",
+	"US-OK": "America/Chicago", "US-OR": "America/Los_Angeles", "US-PA": "America/New_York",
+	"US-RI": "America/New_York", "US-SC": "America/New_York", "US-SD": "America/Chicago",
+	"US-TN": "America/Chicago", "US-TX": "America/Chicago", "US-UT": "America/Denver",
+	"US-VA": "America/New_York", "US-VT": "America/New_York", "US-WA": "America/Los_Angeles",
+	"US-WI": "America/Chicago", "US-WV": "America/New_York", "US-WY": "America/Denver",
+	// Canada
+	"CA-AB": "America/Edmonton", "CA-BC": "America/Vancouver", "CA-MB": "America/Winnipeg",
+	"CA-NB": "America/Moncton", "CA-NL": "America/St_Johns", "CA-NS": "America/Halifax",
+	"CA-NT": "America/Yellowknife", "CA-NU": "America/Iqaluit", "CA-ON": "America/Toronto",
+	"CA-PE": "America/Halifax", "CA-QC": "America/Toronto", "CA-SK": "America/Regina",
+	"CA-YT": "America/Whitehorse",
+	// Australia
+	"AU-ACT": "Australia/Sydney", "AU-NSW": "Australia/Sydney", "AU-NT": "Australia/Darwin",
+	"AU-QLD": "Australia/Brisbane", "AU-SA": "Australia/Adelaide", "AU-TAS": "Australia/Hobart",
+	"AU-VIC": "Australia/Melbourne", "AU-WA": "Australia/Perth",
+	// Russia
+	"RU-KGD": "Europe/Kaliningrad",
+	"RU-AD":  "Europe/Moscow", "RU-ARK": "Europe/Moscow", "RU-BEL": "Europe/Moscow",
+	"RU-BRY": "Europe/Moscow", "RU-CE": "Europe/Moscow", "RU-CU": "Europe/Moscow",
+	"RU-DA": "Europe/Moscow", "RU-IN": "Europe/Moscow", "RU-IVA": "Europe/Moscow",
+	"RU-KB": "Europe/Moscow", "RU-KC": "Europe/Moscow", "RU-KDA": "Europe/Moscow",
+	"RU-KIR": "Europe/Kirov", "RU-KL": "Europe/Moscow", "RU-KLU": "Europe/Moscow",
+	"RU-KO": "Europe/Moscow", "RU-KOS": "Europe/Moscow", "RU-KR": "Europe/Moscow",
+	"RU-KRS": "Europe/Moscow", "RU-LEN": "Europe/Moscow", "RU-LIP": "Europe/Moscow",
+	"RU-ME": "Europe/Moscow", "RU-MO": "Europe/Moscow", "RU-MOS": "Europe/Moscow",
+	"RU-MOW": "Europe/Moscow", "RU-MUR": "Europe/Moscow", "RU-NEN": "Europe/Moscow",
+	"RU-NGR": "Europe/Moscow", "RU-NIZ": "Europe/Moscow", "RU-ORL": "Europe/Moscow",
+	"RU-PNZ": "Europe/Moscow", "RU-PSK": "Europe/Moscow", "RU-ROS": "Europe/Moscow",
+	"RU-RYA": "Europe/Moscow", "RU-SE": "Europe/Moscow", "RU-SMO": "Europe/Moscow",
+	"RU-SPE": "Europe/Moscow", "RU-STA": "Europe/Moscow", "RU-TA": "Europe/Moscow",
+	"RU-TAM": "Europe/Moscow", "RU-TUL": "Europe/Moscow", "RU-TVE": "Europe/Moscow",
+	"RU-VGG": "Europe/Volgograd", "RU-VLA": "Europe/Moscow", "RU-VLG": "Europe/Moscow",
+	"RU-VOR": "Europe/Moscow", "RU-YAR": "Europe/Moscow",
+	"RU-AST": "Europe/Astrakhan", "RU-SAM": "Europe/Samara", "RU-SAR": "Europe/Saratov",
+	"RU-UD": "Europe/Samara", "RU-ULY": "Europe/Ulyanovsk",
+	"RU-BA": "Asia/Yekaterinburg", "RU-CHE": "Asia/Yekaterinburg", "RU-KGN": "Asia/Yekaterinburg",
+	"RU-KHM": "Asia/Yekaterinburg", "RU-ORE": "Asia/Yekaterinburg", "RU-PER": "Asia/Yekaterinburg",
+	"RU-SVE": "Asia/Yekaterinburg", "RU-TYU": "Asia/Yekaterinburg", "RU-YAN": "Asia/Yekaterinburg",
+	"RU-OMS": "Asia/Omsk", "RU-NVS": "Asia/Novosibirsk", "RU-TOM": "Asia/Tomsk",
+	"RU-AL": "Asia/Barnaul", "RU-ALT": "Asia/Barnaul", "RU-KEM": "Asia/Novokuznetsk",
+	"RU-KK": "Asia/Krasnoyarsk", "RU-KYA": "Asia/Krasnoyarsk", "RU-TY": "Asia/Krasnoyarsk",
+	"RU-BU": "Asia/Irkutsk", "RU-IRK": "Asia/Irkutsk", "RU-ZAB": "Asia/Chita",
+	"RU-AMU": "Asia/Yakutsk", "RU-SA": "Asia/Yakutsk",
+	"RU-KHA": "Asia/Vladivostok", "RU-PRI": "Asia/Vladivostok", "RU-YEV": "Asia/Vladivostok",
+	"RU-MAG": "Asia/Magadan", "RU-SAK": "Asia/Sakhalin",
+	"RU-KAM": "Asia/Kamchatka", "RU-CHU": "Asia/Anadyr",
+	// Brazil, the rest is America/Sao_Paulo
+	"BR-AC": "America/Rio_Branco", "BR-AL": "America/Maceio", "BR-AM": "America/Manaus",
+	"BR-AP": "America/Belem", "BR-BA": "America/Bahia", "BR-CE": "America/Fortaleza",
+	"BR-MA": "America/Fortaleza", "BR-MS": "America/Campo_Grande", "BR-MT": "America/Cuiaba",
+	"BR-PA": "America/Belem", "BR-PB": "America/Fortaleza", "BR-PE": "America/Recife",
+	"BR-PI": "America/Fortaleza", "BR-RN": "America/Fortaleza", "BR-RO": "America/Porto_Velho",
+	"BR-RR": "America/Boa_Vista", "BR-SE": "America/Maceio", "BR-TO": "America/Araguaina",
+	// Mexico, the rest is America/Mexico_City
+	"MX-BCN": "America/Tijuana", "MX-BCS": "America/Mazatlan", "MX-CAM": "America/Merida",
+	"MX-CHH": "America/Chihuahua", "MX-COA": "America/Monterrey", "MX-NAY": "America/Mazatlan",
+	"MX-NLE": "America/Monterrey", "MX-ROO": "America/Cancun", "MX-SIN": "America/Mazatlan",
+	"MX-SON": "America/Hermosillo", "MX-TAM": "America/Matamoros", "MX-YUC": "America/Merida",
+	// Indonesia, the rest is Asia/Jakarta
+	"ID-KB": "Asia/Pontianak", "ID-KT": "Asia/Pontianak",
+	"ID-BA": "Asia/Makassar", "ID-GO": "Asia/Makassar", "ID-KI": "Asia/Makassar",
+	"ID-KS": "Asia/Makassar", "ID-KU": "Asia/Makassar", "ID-NB": "Asia/Makassar",
+	"ID-NT": "Asia/Makassar", "ID-SA": "Asia/Makassar", "ID-SG": "Asia/Makassar",
+	"ID-SN": "Asia/Makassar", "ID-SR": "Asia/Makassar", "ID-ST": "Asia/Makassar",
+	"ID-MA": "Asia/Jayapura", "ID-MU": "Asia/Jayapura", "ID-PA": "Asia/Jayapura",
+	"ID-PB": "Asia/Jayapura", "ID-PD": "Asia/Jayapura", "ID-PE": "Asia/Jayapura",
+	"ID-PS": "Asia/Jayapura", "ID-PT": "Asia/Jayapura",
+	// Kazakhstan, the rest is Asia/Almaty
+	"KZ-AKT": "Asia/Aqtobe", "KZ-ATY": "Asia/Atyrau", "KZ-KZY": "Asia/Qyzylorda",
+	"KZ-MAN": "Asia/Aqtau", "KZ-ZAP": "Asia/Oral",
+	// Democratic Republic of the Congo, the rest is Africa/Lubumbashi
+	"CD-BC": "Africa/Kinshasa", "CD-EQ": "Africa/Kinshasa", "CD-KG": "Africa/Kinshasa",
+	"CD-KN": "Africa/Kinshasa", "CD-KW": "Africa/Kinshasa", "CD-MN": "Africa/Kinshasa",
+	"CD-MO": "Africa/Kinshasa", "CD-NU": "Africa/Kinshasa", "CD-SU": "Africa/Kinshasa",
+	"CD-TU": "Africa/Kinshasa",
+	// Outlying islands
+	"EC-W": "Pacific/Galapagos", "ES-CN": "Atlantic/Canary", "NZ-CIT": "Pacific/Chatham",
+	"PT-20": "Atlantic/Azores", "PT-30": "Atlantic/Madeira",
+	"FM-KSA": "Pacific/Kosrae", "FM-TRK": "Pacific/Chuuk", "FM-YAP": "Pacific/Chuuk",
+	"KI-L": "Pacific/Kiritimati", "KI-P": "Pacific/Enderbury",
+}
+
+// LocationTimezone returns IANA timezone name of the location such as
+// "America/New_York", looked up by ISO 3166-2 region code or, if the region
+// is not listed, by ISO 3166-1 country code. Returns an empty string if
+// neither is known, since a timezone guessed from the coordinates would not
+// take into account daylight saving time and would make the clients show
+// wrong local times.
+func LocationTimezone(countryCode string, region string) string {
+	if tz, ok := regionTimezones[region]; ok {
+		return tz
+	}
+	return countryTimezones[countryCode]
+}
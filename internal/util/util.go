@@ -8,47 +8,142 @@
 package util
 
 import (
+	"compress/gzip"
+	"context"
 	"encoding/csv"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
+	"math/rand"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
 )
 
 // SetCORSHeaders modifies headers of http.ResponseWriter by adding headers
-// which allow CORS requests
-func SetCORSHeaders(w http.ResponseWriter) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+// which allow CORS requests with specified comma-separated methods. If
+// allowedOrigins is empty, requests from any origin are allowed, otherwise
+// only the request's Origin found in allowedOrigins is allowed and the
+// headers are not added for other origins.
+func SetCORSHeaders(w http.ResponseWriter, r *http.Request, methods string, allowedOrigins []string) {
+	origin := "*"
+	if len(allowedOrigins) > 0 {
+		// Response depends on Origin even if the origin is not allowed
+		w.Header().Add("Vary", "Origin")
+		origin = r.Header.Get("Origin")
+		if !OriginAllowed(origin, allowedOrigins) {
+			return
+		}
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Set("Access-Control-Allow-Methods", methods)
 	w.Header().Set("Access-Control-Allow-Headers", "*")
 }
 
+// OriginAllowed checks whether the origin is in the list of allowed origins;
+// origins are compared case-insensitively.
+func OriginAllowed(origin string, allowedOrigins []string) bool {
+	if len(origin) == 0 {
+		return false
+	}
+	for _, o := range allowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(strings.TrimSpace(o), "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
 // ServeOptions form a response of an OPTIONS request. If the request is a
-// preflight CORS request, corresponding CORS headers are set. If the request
-//
-func ServeOptions(w http.ResponseWriter, r *http.Request, readOnly bool, allowCORS bool) {
+// preflight CORS request, corresponding CORS headers are set and browsers
+// are allowed to cache the preflight result for maxAge. If the request
+// is a query for allowed methods, Allow header is set. Methods is a
+// comma-separated list of allowed methods. AllowedOrigins are the origins
+// allowed for CORS requests as in SetCORSHeaders.
+func ServeOptions(w http.ResponseWriter, r *http.Request, methods string, allowCORS bool, allowedOrigins []string, maxAge time.Duration) {
 	m := r.Header.Get("Access-Control-Request-Method")
 	h := r.Header.Get("Access-Control-Request-Headers")
 	o := r.Header.Get("Origin")
 	if allowCORS && (len(m) > 0 || len(h) > 0 || len(o) > 0) {
 		// Respond to a preflight CORS request
-		SetCORSHeaders(w)
+		SetCORSHeaders(w, r, methods, allowedOrigins)
+		if maxAge > 0 && len(w.Header().Get("Access-Control-Allow-Origin")) > 0 {
+			w.Header().Set("Access-Control-Max-Age",
+				strconv.FormatInt(int64(maxAge/time.Second), 10))
+		}
 	} else {
 		// Respond to a query for allowed request methods
-		if readOnly {
-			w.Header().Set("Allow", "GET, HEAD, OPTIONS")
-		} else {
-			w.Header().Set("Allow", "GET, HEAD, POST, PUT, DELETE, OPTIONS")
-		}
+		w.Header().Set("Allow", methods)
 		w.Header().Set("Cache-control", "no-cache")
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// ParseCIDRs parses a list of CIDR notation IP networks, such as
+// "192.0.2.0/24" or "2001:db8::/32".
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	result := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(strings.TrimSpace(c))
+		if err != nil {
+			return result, fmt.Errorf("Unable to parse CIDR %s: %s", c, err.Error())
+		}
+		result = append(result, n)
+	}
+	return result, nil
+}
+
+// ClientIP determines IP address of the client which made the request.
+// If the request comes from one of the trusted proxies, X-Forwarded-For or
+// X-Real-IP header is used to determine the client address. X-Forwarded-For
+// is scanned from right to left, skipping trusted proxies. If the request
+// does not come from a trusted proxy, the headers are ignored to prevent
+// spoofing and the request's remote address is used.
+func ClientIP(r *http.Request, trustedCIDRs []*net.IPNet) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	if !ipInNetworks(remote, trustedCIDRs) {
+		return remote
+	}
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		addr := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(addr) - 1; i >= 0; i-- {
+			a := strings.TrimSpace(addr[i])
+			if net.ParseIP(a) == nil {
+				break
+			}
+			if !ipInNetworks(a, trustedCIDRs) || i == 0 {
+				return a
+			}
+		}
+	}
+	if xri := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(xri) != nil {
+		return xri
+	}
+	return remote
+}
+
+func ipInNetworks(addr string, networks []*net.IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 // ParseURLQueryList parses a list specified in a URL query
 // For example list "item1,item2,item3" results in slice {"item1", "item2",
 // "item3"}
@@ -61,9 +156,25 @@ func ParseURLQueryList(queryValues []string) []string {
 	return result
 }
 
+// RetryPolicy specifies how GetFromURL retries the requests which failed due
+// to network errors or 5xx responses. Zero value means a single attempt.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts including the first one
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, doubled before each
+	// following retry
+	BaseDelay time.Duration
+	// Log receives warnings about failed attempts; StdLogger is used if nil
+	Log Logger
+}
+
 // GetFromURL performs a GET request to specified URL to get the content.
+// Returns nil io.ReadCloser and nil error if the content was not modified
+// since lastUpdated. Gzip-compressed content (.gz URL or Content-Encoding:
+// gzip response) is decompressed transparently. Failed requests are retried as specified by retry;
+// the error of the last attempt is returned if all attempts fail.
 // The returned io.ReadCloser MUST be closed by caller.
-func GetFromURL(url string, lastUpdated time.Time) (io.ReadCloser, error) {
+func GetFromURL(url string, lastUpdated time.Time, retry RetryPolicy) (io.ReadCloser, error) {
 	netTransport := &http.Transport{
 		Dial: (&net.Dialer{
 			Timeout: 60 * time.Second,
@@ -74,33 +185,82 @@ func GetFromURL(url string, lastUpdated time.Time) (io.ReadCloser, error) {
 		Timeout:   time.Second * 60,
 		Transport: netTransport,
 	}
-	head, err := httpClient.Head(url)
+	delay := retry.BaseDelay
+	for attempt := 1; ; attempt++ {
+		body, retryable, err := getFromURLOnce(httpClient, url, lastUpdated)
+		if err == nil || !retryable || attempt >= retry.MaxAttempts {
+			return body, err
+		}
+		LoggerOrDefault(retry.Log).Warn("Attempt %d of %d failed, retrying in %v: %s",
+			attempt, retry.MaxAttempts, delay, err.Error())
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// getFromURLOnce performs a single attempt of GetFromURL. Returns whether
+// the request may succeed if retried.
+func getFromURLOnce(httpClient *http.Client, url string, lastUpdated time.Time) (io.ReadCloser, bool, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("HEAD request to %s error: %s", url, err.Error())
+		return nil, false, err
 	}
-	head.Body.Close()
-	if head.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HEAD request to %s resulted in code %d", url, head.StatusCode)
+	if lastUpdated.After(time.Unix(0, 0)) {
+		// Server responds with 304 Not Modified instead of the content if
+		// it was not modified since the last update
+		req.Header.Set("If-Modified-Since", lastUpdated.UTC().Format(http.TimeFormat))
 	}
-	lastModified := head.Header["Last-Modified"]
-	if len(lastModified) == 1 {
-		lastModTime, err := time.Parse(time.RFC1123, lastModified[0])
-		if err != nil {
-			return nil, fmt.Errorf("Cannot parse Last-Modified: %s (requested %s)", lastModified[0], url)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, true, fmt.Errorf("Request to %s error: %s", url, err.Error())
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if !isGzipResponse(resp) {
+			return resp.Body, false, nil
 		}
-		if lastModTime.Before(lastUpdated) {
-			return nil, nil
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			resp.Body.Close()
+			return nil, false, fmt.Errorf("Gzip content from %s error: %s", url, err.Error())
 		}
+		return &gzipReadCloser{Reader: gz, body: resp.Body}, false, nil
+	case http.StatusNotModified:
+		resp.Body.Close()
+		return nil, false, nil
 	}
+	resp.Body.Close()
+	return nil, retryableStatus(resp.StatusCode),
+		fmt.Errorf("Request to %s resulted in code %d", url, resp.StatusCode)
+}
 
-	resp, err := httpClient.Get(url)
-	if err != nil {
-		return nil, err
+// isGzipResponse checks whether response content is gzip-compressed and was
+// not already decompressed by http.Transport
+func isGzipResponse(resp *http.Response) bool {
+	if resp.Uncompressed {
+		return false
 	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Request to %s resulted in code %d", url, resp.StatusCode)
+	return strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") ||
+		strings.HasSuffix(resp.Request.URL.Path, ".gz")
+}
+
+// gzipReadCloser decompresses the response body; closing it closes both
+// gzip reader and the body
+type gzipReadCloser struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (r *gzipReadCloser) Close() error {
+	gzErr := r.Reader.Close()
+	if err := r.body.Close(); err != nil {
+		return err
 	}
-	return resp.Body, err
+	return gzErr
+}
+
+func retryableStatus(code int) bool {
+	return code >= 500 && code <= 599
 }
 
 // ValidateICAOLocation validates a string for accordance to ICAO location rules.
@@ -109,7 +269,7 @@ func ValidateICAOLocation(loc string) bool {
 	if len(loc) != 4 {
 		return false
 	}
-	if loc[0] < 'A' && loc[1] > 'Z' {
+	if loc[0] < 'A' || loc[0] > 'Z' {
 		return false
 	}
 	for i := 1; i < len(loc); i++ {
@@ -162,33 +322,131 @@ func ParseCsvHeader(src *csv.Reader, fieldNames []string) ([]int, error) {
 	return result, nil
 }
 
+// MinExpireSeconds is the minimum expiration period returned by
+// ExpireSeconds; Redis does not accept non-positive expiration periods.
+const MinExpireSeconds = 1
+
+// ErrExpired is returned by ExpireSeconds if the expiration time is already
+// in the past.
+var ErrExpired = errors.New("Expiration time is in the past")
+
+// reportTimeFormats are the time formats used in aviationweather.gov feeds.
+// Time without time zone is UTC. Fractional seconds are accepted by all
+// formats.
+var reportTimeFormats = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+}
+
+// ParseReportTime parses the time of METAR observation or TAF validity in
+// one of the formats used in aviationweather.gov feeds.
+func ParseReportTime(timeStr string) (time.Time, error) {
+	for _, f := range reportTimeFormats {
+		if tm, err := time.Parse(f, timeStr); err == nil {
+			return tm, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Unknown time format %s", timeStr)
+}
+
 // ExpireSeconds calculates expiration period in seconds since current moment,
 // based on the start date and expiration period since start date.
+// The result is never less than MinExpireSeconds; ErrExpired is returned if
+// the expiration time is already in the past. If the start date cannot be
+// parsed, expire is returned along with the error.
 func ExpireSeconds(timeStr string, expire int64) (int64, error) {
-	const timeFormat = time.RFC3339
-	tm, err := time.Parse(timeFormat, timeStr)
+	tm, err := ParseReportTime(timeStr)
 	if err != nil {
+		if expire < MinExpireSeconds {
+			expire = MinExpireSeconds
+		}
 		return expire, err
 	}
-	return tm.Unix() + expire - time.Now().Unix(), nil
+	result := tm.Unix() + expire - time.Now().Unix()
+	if result < MinExpireSeconds {
+		return MinExpireSeconds, ErrExpired
+	}
+	return result, nil
 }
 
 // Schedule arranges a periodical execution of function f with a goroutine.
 // In this implementation delay time starts counting once the function
-// call is completed.
+// call is completed. Sending to or closing the returned channel stops the
+// execution.
 func Schedule(f func(), delay time.Duration) chan bool {
+	return ScheduleWithJitter(f, delay, 0)
+}
+
+// ScheduleWithJitter is the same as Schedule but the first execution is
+// delayed by a random duration of up to jitter, so that the functions
+// scheduled at the same time are not executed simultaneously.
+func ScheduleWithJitter(f func(), delay time.Duration, jitter time.Duration) chan bool {
 	stop := make(chan bool)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+	ScheduleWithJitterContext(ctx, f, delay, jitter)
+	return stop
+}
+
+// ScheduleContext is the same as Schedule but the execution stops once ctx
+// is cancelled. The returned channel is closed when the goroutine exits,
+// after the function call in progress, if any, is completed.
+func ScheduleContext(ctx context.Context, f func(), delay time.Duration) <-chan struct{} {
+	return ScheduleWithJitterContext(ctx, f, delay, 0)
+}
+
+// ScheduleWithJitterContext is the same as ScheduleContext but the first
+// execution is delayed by a random duration of up to jitter.
+func ScheduleWithJitterContext(ctx context.Context, f func(), delay time.Duration, jitter time.Duration) <-chan struct{} {
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
+		// A single timer is reset after each call rather than creating a
+		// new one, so that no timers are left running
+		timer := time.NewTimer(randomDuration(jitter))
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return
+		}
 		for {
+			if ctx.Err() != nil {
+				return
+			}
 			f()
+			timer.Reset(delay)
 			select {
-			case <-time.Tick(delay):
-			case <-stop:
+			case <-timer.C:
+			case <-ctx.Done():
 				return
 			}
 		}
 	}()
-	return stop
+	return done
+}
+
+// Seeded separately from global source, so that different processes
+// started at the same time get different jitter
+var jitterRand = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// randomDuration returns a random duration in range [0, max), or zero if max
+// is not positive.
+func randomDuration(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	jitterRand.Lock()
+	defer jitterRand.Unlock()
+	return time.Duration(jitterRand.Int63n(int64(max)))
 }
 
 // ServeStaticFile reads the file and serves it via specified
@@ -202,3 +460,91 @@ func ServeStaticFile(w http.ResponseWriter, path string) error {
 	_, err = w.Write(file)
 	return err
 }
+
+// PressureAltitude calculates pressure altitude in feet from the elevation
+// in feet and altimeter setting in inches of mercury.
+func PressureAltitude(elevationFeet float64, altimeterInHg float64) float64 {
+	const stdAltimeterInHg = 29.92
+	const feetPerInHg = 1000
+	return elevationFeet + (stdAltimeterInHg-altimeterInHg)*feetPerInHg
+}
+
+// DensityAltitude calculates density altitude in feet from the pressure
+// altitude in feet and outside air temperature in degrees Celsius, using a
+// common approximation of 120 feet per degree of deviation from standard
+// atmosphere temperature.
+func DensityAltitude(pressureAltitudeFeet float64, temperatureC float64) float64 {
+	const isaSeaLevelTempC = 15
+	const isaLapseRatePerFoot = 2.0 / 1000
+	const feetPerDegree = 120
+	isaTemp := isaSeaLevelTempC - pressureAltitudeFeet*isaLapseRatePerFoot
+	return pressureAltitudeFeet + (temperatureC-isaTemp)*feetPerDegree
+}
+
+// GeohashBounds decodes a geohash string into the bounding box of the
+// corresponding cell. Only geohash precision from 3 to 7 characters is
+// accepted.
+func GeohashBounds(hash string) (minLat, minLon, maxLat, maxLon float64, err error) {
+	const alphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+	if len(hash) < 3 || len(hash) > 7 {
+		return 0, 0, 0, 0, fmt.Errorf("Geohash %s precision must be from 3 to 7", hash)
+	}
+	minLat, maxLat = -90.0, 90.0
+	minLon, maxLon = -180.0, 180.0
+	even := true
+	for _, c := range strings.ToLower(hash) {
+		idx := strings.IndexRune(alphabet, c)
+		if idx < 0 {
+			return 0, 0, 0, 0, fmt.Errorf("Invalid character %c in geohash %s", c, hash)
+		}
+		for bit := 4; bit >= 0; bit-- {
+			set := idx&(1<<uint(bit)) != 0
+			if even {
+				mid := (minLon + maxLon) / 2
+				if set {
+					minLon = mid
+				} else {
+					maxLon = mid
+				}
+			} else {
+				mid := (minLat + maxLat) / 2
+				if set {
+					minLat = mid
+				} else {
+					maxLat = mid
+				}
+			}
+			even = !even
+		}
+	}
+	return minLat, minLon, maxLat, maxLon, nil
+}
+
+// DistanceKm calculates great-circle distance in kilometers between two
+// points specified by latitude and longitude in decimal degrees, using
+// haversine formula with the same Earth radius as Redis GEO commands.
+func DistanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6372.7976
+	const rad = math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// SanitizeReport removes non-printable characters from a report string,
+// trims it and collapses consecutive whitespace characters (including tabs
+// and line breaks) into a single space.
+func SanitizeReport(report string) string {
+	s := strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return ' '
+		}
+		if !unicode.IsPrint(r) {
+			return -1
+		}
+		return r
+	}, report)
+	return strings.Join(strings.Fields(s), " ")
+}
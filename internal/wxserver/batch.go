@@ -0,0 +1,103 @@
+/*
+* Copyright (C) 2020 Nick Naumenko (https://gitlab.com/nnaumenko)
+* All rights reserved.
+* This software may be modified and distributed under the terms
+* of the MIT license. See the LICENSE file for details.
+ */
+
+package wxserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/nnaumenko/wx/internal/util"
+	"github.com/nnaumenko/wx/pkg/wxtypes"
+)
+
+const (
+	maxBatchRequests  = 16
+	maxBatchLocations = 64
+	maxBatchBodyBytes = 64 * 1024
+)
+
+// checkBatch validates the number of batch sub-requests and the total number
+// of locations in the batch.
+func checkBatch(batch []wxtypes.BatchRequest) error {
+	if len(batch) == 0 {
+		return fmt.Errorf("Batch is empty")
+	}
+	if len(batch) > maxBatchRequests {
+		return fmt.Errorf("%d requests specified in batch while maximum of %d is allowed",
+			len(batch), maxBatchRequests)
+	}
+	numLocations := 0
+	for i := range batch {
+		numLocations += len(uniqueLocations(batch[i].Locations))
+	}
+	if numLocations > maxBatchLocations {
+		return fmt.Errorf("%d locations specified in batch while maximum of %d is allowed",
+			numLocations, maxBatchLocations)
+	}
+	return nil
+}
+
+func serveBatchRequest(ctx *HandlerContext, reqCtx context.Context, req wxtypes.BatchRequest) wxtypes.BatchResponse {
+	resp := wxtypes.BatchResponse{
+		Endpoint: req.Endpoint,
+		Data:     make([]*wxtypes.DataICAOLocation, 0),
+	}
+	switch req.Endpoint {
+	case endpointMetar, endpointTaf, endpointLocation, endpointAll:
+	default:
+		resp.Error = fmt.Sprintf("Unknown Endpoint %s", req.Endpoint)
+		return resp
+	}
+	if len(req.Locations) == 0 {
+		resp.Error = "Location not specified"
+		return resp
+	}
+	locations := uniqueLocations(req.Locations)
+	for _, l := range locations {
+		if !util.ValidateICAOLocation(l) {
+			resp.Error = fmt.Sprintf("Invalid ICAO location code format %s", l)
+			return resp
+		}
+	}
+	ld, err := queryDatabase(ctx, reqCtx, req.Endpoint, locations, QueryParameters{})
+	if err != nil {
+		resp.Error = fmt.Sprintf("Error retreiving data for locations %v: %s", locations, err)
+		return resp
+	}
+	if ld != nil {
+		resp.Data = ld
+	}
+	return resp
+}
+
+// handleBatch serves multiple sub-requests submitted as JSON array of
+// BatchRequest in the request body. Responds with JSON array of
+// BatchResponse in the same order as sub-requests. A failure of a single
+// sub-request does not cause the whole batch to fail.
+func handleBatch(ctx *HandlerContext) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []wxtypes.BatchRequest
+		body := http.MaxBytesReader(w, r.Body, maxBatchBodyBytes)
+		if err := json.NewDecoder(body).Decode(&batch); err != nil {
+			msg := fmt.Sprintf("Error parsing batch request: %s", err.Error())
+			writeJSONError(w, http.StatusBadRequest, msg)
+			return
+		}
+		if err := checkBatch(batch); err != nil {
+			writeJSONError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		result := make([]wxtypes.BatchResponse, len(batch))
+		for i, req := range batch {
+			result[i] = serveBatchRequest(ctx, r.Context(), req)
+		}
+		serveJSON(ctx, w, result)
+	})
+}
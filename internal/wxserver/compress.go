@@ -0,0 +1,124 @@
+/*
+* Copyright (C) 2020 Nick Naumenko (https://gitlab.com/nnaumenko)
+* All rights reserved.
+* This software may be modified and distributed under the terms
+* of the MIT license. See the LICENSE file for details.
+ */
+
+package wxserver
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+// gzipMinSize is the minimum response size to be compressed; smaller
+// responses are not worth the overhead
+const gzipMinSize = 1024
+
+// gzipResponseWriter buffers the beginning of the response and compresses
+// it if the response turns out to be at least gzipMinSize bytes.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status int
+	buf    []byte
+	gz     *gzip.Writer
+	raw    bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	// Status is delayed until it is known whether response is compressed
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	switch {
+	case w.gz != nil:
+		return w.gz.Write(b)
+	case w.raw:
+		return w.ResponseWriter.Write(b)
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) < gzipMinSize {
+		return len(b), nil
+	}
+	if err := w.flushBuffer(true); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// flushBuffer writes status and buffered data, compressed or as is
+func (w *gzipResponseWriter) flushBuffer(compress bool) error {
+	h := w.Header()
+	if len(h.Get("Content-Type")) == 0 && len(w.buf) > 0 {
+		// Detect content type on uncompressed data like net/http does
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	if len(h.Get("Content-Encoding")) > 0 {
+		compress = false
+	}
+	if compress {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+	}
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	buf := w.buf
+	w.buf = nil
+	if !compress {
+		w.raw = true
+		_, err := w.ResponseWriter.Write(buf)
+		return err
+	}
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+	_, err := w.gz.Write(buf)
+	return err
+}
+
+// close writes what is still buffered and finishes compressed stream
+func (w *gzipResponseWriter) close() error {
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	if w.raw || (len(w.buf) == 0 && w.status == 0) {
+		return nil
+	}
+	return w.flushBuffer(false)
+}
+
+// acceptsGzip checks whether gzip is among the encodings in Accept-Encoding
+// header and is not explicitly refused with q=0.
+func acceptsGzip(r *http.Request) bool {
+	for _, e := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		p := strings.Split(e, ";")
+		if strings.TrimSpace(p[0]) != "gzip" {
+			continue
+		}
+		for _, q := range p[1:] {
+			q = strings.ReplaceAll(strings.TrimSpace(q), " ", "")
+			if q == "q=0" || q == "q=0.0" || q == "q=0.00" || q == "q=0.000" {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func compressResponse(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := gzipResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(&gw, r)
+		gw.close()
+	})
+}
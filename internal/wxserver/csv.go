@@ -0,0 +1,106 @@
+/*
+* Copyright (C) 2020 Nick Naumenko (https://gitlab.com/nnaumenko)
+* All rights reserved.
+* This software may be modified and distributed under the terms
+* of the MIT license. See the LICENSE file for details.
+ */
+
+package wxserver
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"github.com/nnaumenko/wx/pkg/wxtypes"
+)
+
+const (
+	formatCSV string = "csv"
+
+	contentTypeCSV = "text/csv; charset=utf-8"
+)
+
+var (
+	csvColumnsMetar    = []string{"location", "metar", "metar_type", "metar_observation_time"}
+	csvColumnsTaf      = []string{"location", "taf", "taf_valid_from", "taf_valid_to", "taf_issue_time"}
+	csvColumnsLocation = []string{"location", "name", "city", "country_code", "country_name",
+		"region", "latitude", "longitude", "altitude_meters", "altitude_feet", "timezone", "closed"}
+	csvColumnsAll = append(append(append([]string{}, csvColumnsLocation...),
+		csvColumnsMetar[1:]...), csvColumnsTaf[1:]...)
+)
+
+// csvColumns returns the columns of CSV response: the requested fields if
+// specified, otherwise the fields relevant to the endpoint.
+func csvColumns(endpoint string, qparam QueryParameters) []string {
+	if len(qparam.Fields) > 0 {
+		return qparam.Fields
+	}
+	switch endpoint {
+	case endpointMetar:
+		return csvColumnsMetar
+	case endpointTaf:
+		return csvColumnsTaf
+	case endpointLocation:
+		return csvColumnsLocation
+	}
+	return csvColumnsAll
+}
+
+// csvValue formats a field of location data specified by its JSON name.
+func csvValue(ld *wxtypes.DataICAOLocation, name string) string {
+	v := reflect.ValueOf(ld).Elem()
+	for i, n := range selectableFields {
+		if n != name {
+			continue
+		}
+		f := v.Field(i)
+		if f.Kind() == reflect.Ptr {
+			if f.IsNil() {
+				return ""
+			}
+			f = f.Elem()
+		}
+		switch f.Kind() {
+		case reflect.String:
+			return f.String()
+		case reflect.Int, reflect.Int64:
+			return strconv.FormatInt(f.Int(), 10)
+		case reflect.Float64:
+			return strconv.FormatFloat(f.Float(), 'f', -1, 64)
+		case reflect.Bool:
+			return strconv.FormatBool(f.Bool())
+		}
+		return fmt.Sprint(f.Interface())
+	}
+	return ""
+}
+
+// serveCSV serves location data as CSV with header row and one row per
+// location.
+func serveCSV(ctx *HandlerContext, w http.ResponseWriter, endpoint string, qparam QueryParameters, ld []*wxtypes.DataICAOLocation) {
+	columns := csvColumns(endpoint, qparam)
+	var b bytes.Buffer
+	cw := csv.NewWriter(&b)
+	cw.Write(columns)
+	row := make([]string, len(columns))
+	for _, l := range ld {
+		for i, c := range columns {
+			row[i] = csvValue(l, c)
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		msg := fmt.Sprintf("Error converting to CSV: %s", err)
+		writeJSONError(w, http.StatusInternalServerError, msg)
+		return
+	}
+	setDataEpochHeader(ctx, w)
+	w.Header().Set("Content-Type", contentTypeCSV)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"wx-%s.csv\"", endpoint))
+	w.Write(b.Bytes())
+}
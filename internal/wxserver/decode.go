@@ -0,0 +1,88 @@
+/*
+* Copyright (C) 2020 Nick Naumenko (https://gitlab.com/nnaumenko)
+* All rights reserved.
+* This software may be modified and distributed under the terms
+* of the MIT license. See the LICENSE file for details.
+ */
+
+package wxserver
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/nnaumenko/wx/internal/metar"
+	"github.com/nnaumenko/wx/internal/util"
+)
+
+const (
+	endpointDecode string = "decode"
+
+	// formatIWXXM serves decoded METAR mapped to IWXXM element names and
+	// units instead of native format
+	formatIWXXM string = "iwxxm"
+)
+
+// decodedFormat returns the format of decoded METAR specified in URL query,
+// json or iwxxm.
+func decodedFormat(f string) (string, error) {
+	switch f {
+	case "", formatJSON:
+		return formatJSON, nil
+	case formatIWXXM:
+		return formatIWXXM, nil
+	}
+	return "", fmt.Errorf("Unsupported format %s, json or iwxxm is allowed", f)
+}
+
+// decodedMETAR returns decoded METAR in the specified format.
+func decodedMETAR(d *metar.DecodedMETAR, format string) interface{} {
+	if format == formatIWXXM {
+		r := d.IWXXM()
+		return &r
+	}
+	return d
+}
+
+// handleDecode serves decoded current METAR for a single location specified
+// in the path such as /decode/metar/KJFK.
+func handleDecode(ctx *HandlerContext) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format, err := decodedFormat(strings.ToLower(r.URL.Query().Get(paramFormat)))
+		if err != nil {
+			writeJSONError(w, http.StatusNotAcceptable, err.Error())
+			return
+		}
+		p := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if len(p) != 3 || p[0] != endpointDecode || p[1] != endpointMetar {
+			msg := fmt.Sprintf("Unable to parse URL path %s", r.URL.Path)
+			writeJSONError(w, http.StatusBadRequest, msg)
+			return
+		}
+		location := strings.ToUpper(p[2])
+		if !util.ValidateICAOLocation(location) {
+			msg := fmt.Sprintf("Invalid ICAO location code format %s", location)
+			writeJSONError(w, http.StatusUnprocessableEntity, msg)
+			return
+		}
+		ld, err := ctx.Db.GetMETARsContext(r.Context(), []string{location})
+		if err != nil {
+			msg := fmt.Sprintf("Error retreiving METAR for location %s: %s", location, err)
+			writeJSONError(w, http.StatusInternalServerError, msg)
+			return
+		}
+		if len(ld) != 1 {
+			msg := fmt.Sprintf("No current METAR for location %s", location)
+			writeJSONError(w, http.StatusNotFound, msg)
+			return
+		}
+		d, err := metar.DecodeMETAR(ld[0].Metar)
+		if err != nil {
+			msg := fmt.Sprintf("Unable to decode METAR for location %s: %s", location, err)
+			writeJSONError(w, http.StatusUnprocessableEntity, msg)
+			return
+		}
+		serveJSON(ctx, w, decodedMETAR(&d, format))
+	})
+}
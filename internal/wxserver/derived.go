@@ -0,0 +1,123 @@
+/*
+* Copyright (C) 2020 Nick Naumenko (https://gitlab.com/nnaumenko)
+* All rights reserved.
+* This software may be modified and distributed under the terms
+* of the MIT license. See the LICENSE file for details.
+ */
+
+package wxserver
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/nnaumenko/wx/internal/metar"
+	"github.com/nnaumenko/wx/internal/util"
+	"github.com/nnaumenko/wx/pkg/wxtypes"
+)
+
+// getSingleLocationData retreives location data and reports for a single
+// location. If an error occurs, it is served and nil is returned.
+func getSingleLocationData(ctx *HandlerContext, w http.ResponseWriter, r *http.Request) *wxtypes.DataICAOLocation {
+	_, location, err := parsePath(r.URL.Path)
+	if err != nil {
+		msg := fmt.Sprintf("Error parsing path: %s", err.Error())
+		writeJSONError(w, http.StatusBadRequest, msg)
+		return nil
+	}
+	if !util.ValidateICAOLocation(location) {
+		msg := fmt.Sprintf("Invalid ICAO location code format %s", location)
+		writeJSONError(w, http.StatusUnprocessableEntity, msg)
+		return nil
+	}
+	ld, err := ctx.Db.GetICAOLocationDataContext(r.Context(), []string{location})
+	if err != nil {
+		msg := fmt.Sprintf("Error retreiving data for location %s: %s", location, err)
+		writeJSONError(w, http.StatusInternalServerError, msg)
+		return nil
+	}
+	if len(ld) != 1 {
+		msg := fmt.Sprintf("Location %s is not found", location)
+		writeJSONError(w, http.StatusNotFound, msg)
+		return nil
+	}
+	wxtypes.SplitMetarType(ld[0])
+	return ld[0]
+}
+
+func handleDensityAltitude(ctx *HandlerContext) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ld := getSingleLocationData(ctx, w, r)
+		if ld == nil {
+			return
+		}
+		if len(ld.Metar) == 0 {
+			msg := fmt.Sprintf("No current METAR for location %s", ld.Location)
+			writeJSONError(w, http.StatusUnprocessableEntity, msg)
+			return
+		}
+		d, err := metar.DecodeMETAR(ld.Metar)
+		if err != nil {
+			msg := fmt.Sprintf("Unable to decode METAR %s: %s", ld.Metar, err)
+			writeJSONError(w, http.StatusUnprocessableEntity, msg)
+			return
+		}
+		if d.Temperature == nil {
+			msg := fmt.Sprintf("No temperature in METAR %s", ld.Metar)
+			writeJSONError(w, http.StatusUnprocessableEntity, msg)
+			return
+		}
+		if d.Altimeter == nil {
+			msg := fmt.Sprintf("No altimeter setting in METAR %s", ld.Metar)
+			writeJSONError(w, http.StatusUnprocessableEntity, msg)
+			return
+		}
+		pa := util.PressureAltitude(float64(ld.AltitudeFeet), d.Altimeter.InHg)
+		da := util.DensityAltitude(pa, *d.Temperature)
+		serveJSON(ctx, w, wxtypes.DensityAltitude{
+			Location:             ld.Location,
+			Metar:                ld.Metar,
+			ElevationFeet:        ld.AltitudeFeet,
+			TemperatureCelsius:   *d.Temperature,
+			AltimeterInHg:        d.Altimeter.InHg,
+			PressureAltitudeFeet: int(math.Round(pa)),
+			DensityAltitudeFeet:  int(math.Round(da)),
+		})
+	})
+}
+
+// handleConsistency serves whether current METAR was observed within the
+// validity period of current TAF for a single location.
+func handleConsistency(ctx *HandlerContext) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ld := getSingleLocationData(ctx, w, r)
+		if ld == nil {
+			return
+		}
+		c := wxtypes.Consistency{
+			Location:     ld.Location,
+			MetarMissing: len(ld.Metar) == 0,
+			TafMissing:   len(ld.Taf) == 0,
+		}
+		if !c.MetarMissing {
+			c.MetarObservationTime = ld.MetarObservationTime
+		}
+		if !c.TafMissing {
+			c.TafValidFrom, c.TafValidTo = ld.TafValidFrom, ld.TafValidTo
+		}
+		// Zero time means unknown time
+		obsTime, _ := time.Parse(time.RFC3339, c.MetarObservationTime)
+		validFrom, _ := time.Parse(time.RFC3339, c.TafValidFrom)
+		validTo, _ := time.Parse(time.RFC3339, c.TafValidTo)
+		if !obsTime.IsZero() && !validFrom.IsZero() && !validTo.IsZero() {
+			within := !obsTime.Before(validFrom) && obsTime.Before(validTo)
+			since := int64(obsTime.Sub(validFrom) / time.Second)
+			until := int64(validTo.Sub(obsTime) / time.Second)
+			c.WithinTafValidity = &within
+			c.SinceTafValidFromSeconds, c.UntilTafValidToSeconds = &since, &until
+		}
+		serveJSON(ctx, w, c)
+	})
+}
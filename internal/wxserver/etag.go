@@ -0,0 +1,110 @@
+/*
+* Copyright (C) 2020 Nick Naumenko (https://gitlab.com/nnaumenko)
+* All rights reserved.
+* This software may be modified and distributed under the terms
+* of the MIT license. See the LICENSE file for details.
+ */
+
+package wxserver
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// etagResponseWriter buffers the whole response so that ETag can be
+// calculated from the response body before it is sent.
+type etagResponseWriter struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (w *etagResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *etagResponseWriter) Write(b []byte) (int, error) {
+	return w.buf.Write(b)
+}
+
+// finish sends the buffered response, or 304 Not Modified without body if
+// the client already has the same response. The body of the response to
+// HEAD request is not sent but Content-Length is set to the body size.
+func (w *etagResponseWriter) finish(r *http.Request) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	h := w.Header()
+	if len(h.Get("Content-Type")) == 0 && w.buf.Len() > 0 {
+		h.Set("Content-Type", http.DetectContentType(w.buf.Bytes()))
+	}
+	if w.status != http.StatusNoContent {
+		h.Set("Content-Length", strconv.Itoa(w.buf.Len()))
+	}
+	if w.status != http.StatusOK {
+		w.writeBuffered(r)
+		return
+	}
+	etag := makeETag(w.buf.Bytes())
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		h.Del("Content-Type")
+		h.Del("Content-Length")
+		w.ResponseWriter.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.writeBuffered(r)
+}
+
+func (w *etagResponseWriter) writeBuffered(r *http.Request) {
+	w.ResponseWriter.WriteHeader(w.status)
+	if r.Method != http.MethodHead {
+		w.ResponseWriter.Write(w.buf.Bytes())
+	}
+}
+
+// makeETag returns weak ETag from the hash of the uncompressed body; the ETag
+// is weak because the body may be sent compressed or uncompressed
+func makeETag(body []byte) string {
+	h := fnv.New64a()
+	h.Write(body)
+	return fmt.Sprintf("W/\"%016x\"", h.Sum64())
+}
+
+// etagMatches checks whether ETag is in the If-None-Match header value,
+// using weak comparison as required for If-None-Match.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if len(ifNoneMatch) == 0 {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, t := range strings.Split(ifNoneMatch, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// conditionalResponse adds ETag to GET and HEAD responses and responds with
+// 304 Not Modified if the request's If-None-Match matches the ETag. Responses
+// to HEAD requests are sent without body.
+func conditionalResponse(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ew := etagResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(&ew, r)
+		ew.finish(r)
+	})
+}
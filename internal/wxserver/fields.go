@@ -0,0 +1,43 @@
+/*
+* Copyright (C) 2020 Nick Naumenko (https://gitlab.com/nnaumenko)
+* All rights reserved.
+* This software may be modified and distributed under the terms
+* of the MIT license. See the LICENSE file for details.
+ */
+
+package wxserver
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/nnaumenko/wx/pkg/wxtypes"
+)
+
+const paramFields string = "fields"
+
+// selectableFields lists the response fields which can be requested with
+// fields parameter, the same as JSON keys of DataICAOLocation.
+var selectableFields = locationFieldNames()
+
+func locationFieldNames() []string {
+	t := reflect.TypeOf(wxtypes.DataICAOLocation{})
+	names := make([]string, t.NumField())
+	for i := range names {
+		names[i] = strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+	}
+	return names
+}
+
+// selectFields clears all fields of location data except the specified ones.
+// Since all fields of DataICAOLocation are omitted if empty, the cleared
+// fields are not served.
+func selectFields(ld *wxtypes.DataICAOLocation, fields []string) {
+	v := reflect.ValueOf(ld).Elem()
+	for i, name := range selectableFields {
+		if !containsString(fields, name) {
+			f := v.Field(i)
+			f.Set(reflect.Zero(f.Type()))
+		}
+	}
+}
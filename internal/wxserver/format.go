@@ -0,0 +1,128 @@
+/*
+* Copyright (C) 2020 Nick Naumenko (https://gitlab.com/nnaumenko)
+* All rights reserved.
+* This software may be modified and distributed under the terms
+* of the MIT license. See the LICENSE file for details.
+ */
+
+package wxserver
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/nnaumenko/wx/pkg/wxtypes"
+)
+
+const (
+	paramFormat string = "format"
+
+	formatJSON string = "json"
+	formatXML  string = "xml"
+
+	contentTypeXML = "application/xml; charset=utf-8"
+
+	xmlElementLocation  = "location"
+	xmlElementLocations = "locations"
+)
+
+// xmlLocations is the root element of XML response for multiple locations
+type xmlLocations struct {
+	XMLName   xml.Name                    `xml:"locations"`
+	Locations []*wxtypes.DataICAOLocation `xml:"location"`
+}
+
+// responseFormat returns the format requested with format parameter or, if
+// the parameter is not specified, the first of JSON, XML or CSV media types
+// listed in Accept header. Defaults to JSON.
+func responseFormat(r *http.Request, qparam QueryParameters) string {
+	if len(qparam.Format) > 0 {
+		return qparam.Format
+	}
+	for _, a := range strings.Split(r.Header.Get("Accept"), ",") {
+		switch strings.TrimSpace(strings.Split(a, ";")[0]) {
+		case "application/json":
+			return formatJSON
+		case "application/xml", "text/xml":
+			return formatXML
+		case "text/csv":
+			return formatCSV
+		}
+	}
+	return formatJSON
+}
+
+// serveLocations serves a single location or multiple locations in the
+// format requested by the client, only with the fields requested by the
+// client if any.
+func serveLocations(ctx *HandlerContext, w http.ResponseWriter, r *http.Request, endpoint string, qparam QueryParameters, v interface{}) {
+	w.Header().Add("Vary", "Accept")
+	if len(qparam.Fields) > 0 {
+		switch ld := v.(type) {
+		case *wxtypes.DataICAOLocation:
+			selectFields(ld, qparam.Fields)
+		case []*wxtypes.DataICAOLocation:
+			for _, l := range ld {
+				selectFields(l, qparam.Fields)
+			}
+		}
+	}
+	switch f := responseFormat(r, qparam); f {
+	case formatJSON:
+		serveJSON(ctx, w, v)
+	case formatXML:
+		switch ld := v.(type) {
+		case *wxtypes.DataICAOLocation:
+			serveXML(ctx, w, ld, xmlElementLocation)
+		case []*wxtypes.DataICAOLocation:
+			serveXML(ctx, w, xmlLocations{Locations: ld}, xmlElementLocations)
+		default:
+			msg := fmt.Sprintf("Unable to convert %T to XML", v)
+			writeJSONError(w, http.StatusInternalServerError, msg)
+		}
+	case formatCSV:
+		switch ld := v.(type) {
+		case *wxtypes.DataICAOLocation:
+			serveCSV(ctx, w, endpoint, qparam, []*wxtypes.DataICAOLocation{ld})
+		case []*wxtypes.DataICAOLocation:
+			serveCSV(ctx, w, endpoint, qparam, ld)
+		default:
+			msg := fmt.Sprintf("Unable to convert %T to CSV", v)
+			writeJSONError(w, http.StatusInternalServerError, msg)
+		}
+	default:
+		msg := fmt.Sprintf("Unsupported format %s", f)
+		writeJSONError(w, http.StatusNotAcceptable, msg)
+	}
+}
+
+func serveXML(ctx *HandlerContext, w http.ResponseWriter, v interface{}, element string) {
+	var x []byte
+	var err error
+	start := xml.StartElement{Name: xml.Name{Local: element}}
+	if prettyJSON {
+		x, err = xmlMarshalIndent(v, start, jsonIndent(ctx))
+	} else {
+		x, err = xmlMarshalIndent(v, start, "")
+	}
+	if err != nil {
+		msg := fmt.Sprintf("Error converting to XML: %s", err)
+		writeJSONError(w, http.StatusInternalServerError, msg)
+		return
+	}
+	setDataEpochHeader(ctx, w)
+	w.Header().Set("Content-Type", contentTypeXML)
+	fmt.Fprintf(w, "%s%s\n", xml.Header, x)
+}
+
+func xmlMarshalIndent(v interface{}, start xml.StartElement, indent string) ([]byte, error) {
+	var b strings.Builder
+	enc := xml.NewEncoder(&b)
+	enc.Indent("", indent)
+	if err := enc.EncodeElement(v, start); err != nil {
+		return nil, err
+	}
+	return []byte(b.String()), nil
+}
@@ -0,0 +1,134 @@
+/*
+* Copyright (C) 2020 Nick Naumenko (https://gitlab.com/nnaumenko)
+* All rights reserved.
+* This software may be modified and distributed under the terms
+* of the MIT license. See the LICENSE file for details.
+ */
+
+package wxserver
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/nnaumenko/wx/internal/metar"
+	"github.com/nnaumenko/wx/internal/util"
+	"github.com/nnaumenko/wx/pkg/wxtypes"
+)
+
+// defaultMaxFullLocations is lower than defaultMaxLocations because every
+// METAR in the response is decoded
+const defaultMaxFullLocations = 4
+
+func maxFullLocations(ctx *HandlerContext) int {
+	if ctx.MaxFullLocations == 0 {
+		return defaultMaxFullLocations
+	}
+	return ctx.MaxFullLocations
+}
+
+// fullLocationData is the location data along with raw reports and decoded
+// METAR in native or IWXXM format. If METAR cannot be decoded, DecodeError
+// holds the error message and only raw METAR is served.
+type fullLocationData struct {
+	*wxtypes.DataICAOLocation
+	DecodedMetar interface{} `json:"decoded_metar,omitempty"`
+	DecodeError  string      `json:"decode_error,omitempty"`
+}
+
+func makeFullLocationData(ld *wxtypes.DataICAOLocation, format string) fullLocationData {
+	fld := fullLocationData{DataICAOLocation: ld}
+	if len(ld.Metar) == 0 {
+		return fld
+	}
+	d, err := metar.DecodeMETAR(ld.Metar)
+	if err != nil {
+		fld.DecodeError = fmt.Sprintf("Unable to decode METAR: %s", err.Error())
+		return fld
+	}
+	fld.DecodedMetar = decodedMETAR(&d, format)
+	return fld
+}
+
+// handleFull serves location info, raw METAR and TAF and decoded METAR for
+// one or more locations. All data are retreived in a single database query.
+func handleFull(ctx *HandlerContext) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, locationSingle, err := parsePath(r.URL.Path)
+		if err != nil {
+			msg := fmt.Sprintf("Error parsing path: %s", err.Error())
+			writeJSONError(w, http.StatusBadRequest, msg)
+			return
+		}
+		qparam, err := parseQuery(r.URL.RawQuery)
+		if err != nil {
+			msg := fmt.Sprintf("Error parsing query: %s", err.Error())
+			writeJSONError(w, http.StatusBadRequest, msg)
+			return
+		}
+		// Decoded METAR is not flat and cannot be served as XML or CSV
+		format, err := decodedFormat(qparam.Format)
+		if err != nil {
+			writeJSONError(w, http.StatusNotAcceptable, err.Error())
+			return
+		}
+		locations := qparam.Locations
+		switch {
+		case len(locations) > 0 && len(locationSingle) > 0:
+			msg := fmt.Sprintf(
+				"Single location %s and multiple locations %v "+
+					"must not be specified in the same request",
+				locationSingle, locations)
+			writeJSONError(w, http.StatusUnprocessableEntity, msg)
+			return
+		case len(locationSingle) > 0:
+			locations = []string{locationSingle}
+		case len(locations) == 0:
+			writeJSONError(w, http.StatusUnprocessableEntity, "Location not specified")
+			return
+		}
+		if len(locations) > maxFullLocations(ctx) {
+			msg := fmt.Sprintf("%d location specified while maximum of %d is allowed",
+				len(locations), maxFullLocations(ctx))
+			writeJSONError(w, http.StatusForbidden, msg)
+			return
+		}
+		for _, l := range locations {
+			if !util.ValidateICAOLocation(l) {
+				msg := fmt.Sprintf("Invalid ICAO location code format %s", l)
+				writeJSONError(w, http.StatusUnprocessableEntity, msg)
+				return
+			}
+		}
+		ld, err := queryDatabase(ctx, r.Context(), endpointAll, locations, qparam)
+		if err != nil {
+			msg := fmt.Sprintf("Error retreiving data for locations %v: %s", locations, err)
+			writeJSONError(w, http.StatusInternalServerError, msg)
+			return
+		}
+		if len(locationSingle) > 0 && len(ld) < 1 {
+			msg := fmt.Sprintf("Location %s is not found", locationSingle)
+			writeJSONError(w, http.StatusNotFound, msg)
+			return
+		}
+		if qparam.Sort == sortICAO {
+			sort.SliceStable(ld, func(i, j int) bool {
+				return ld[i].Location < ld[j].Location
+			})
+		}
+		result := make([]fullLocationData, len(ld))
+		for i := range ld {
+			// METAR is only decoded if selected
+			if len(qparam.Fields) > 0 {
+				selectFields(ld[i], qparam.Fields)
+			}
+			result[i] = makeFullLocationData(ld[i], format)
+		}
+		if len(locationSingle) > 0 {
+			serveJSON(ctx, w, result[0])
+			return
+		}
+		serveJSON(ctx, w, result)
+	})
+}
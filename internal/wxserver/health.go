@@ -0,0 +1,51 @@
+/*
+* Copyright (C) 2020 Nick Naumenko (https://gitlab.com/nnaumenko)
+* All rights reserved.
+* This software may be modified and distributed under the terms
+* of the MIT license. See the LICENSE file for details.
+ */
+
+package wxserver
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+const endpointHealth string = "health"
+
+const (
+	healthStatusOk          = "ok"
+	healthStatusUnavailable = "unavailable"
+)
+
+// healthStatus is served by health endpoint
+type healthStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// handleHealth serves the status of the database for liveness and
+// readiness probes. It does not parse the path or query and does not
+// retreive any data so it stays cheap when polled frequently.
+func handleHealth(ctx *HandlerContext) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := healthStatus{Status: healthStatusOk}
+		code := http.StatusOK
+		if err := ctx.Db.Ping(); err != nil {
+			status = healthStatus{Status: healthStatusUnavailable, Error: err.Error()}
+			code = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", contentTypeJSON)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(status)
+	})
+}
+
+// middlewareHealth only rejects the methods other than GET and HEAD; health
+// and metrics endpoints are not logged by default and are not rate-limited
+// so that probes from orchestrators and scrapers are never refused.
+func middlewareHealth(ctx *HandlerContext, next http.Handler) http.Handler {
+	return requestID(logRequest(ctx, checkMethod(ctx, methodsReadOnly, next)))
+}
@@ -0,0 +1,154 @@
+/*
+* Copyright (C) 2020 Nick Naumenko (https://gitlab.com/nnaumenko)
+* All rights reserved.
+* This software may be modified and distributed under the terms
+* of the MIT license. See the LICENSE file for details.
+ */
+
+package wxserver
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nnaumenko/wx/internal/util"
+)
+
+// concurrencyLimiter counts requests being served concurrently for each
+// client IP.
+type concurrencyLimiter struct {
+	max    int
+	mu     sync.Mutex
+	active map[string]int
+}
+
+func newConcurrencyLimiter(max int) *concurrencyLimiter {
+	return &concurrencyLimiter{max: max, active: make(map[string]int)}
+}
+
+// acquire returns false if the client already has maximum number of
+// requests being served.
+func (l *concurrencyLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active[ip] >= l.max {
+		return false
+	}
+	l.active[ip]++
+	return true
+}
+
+func (l *concurrencyLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active[ip]--
+	if l.active[ip] <= 0 {
+		delete(l.active, ip)
+	}
+}
+
+func limitConcurrency(ctx *HandlerContext, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ctx.concurrency == nil || ctx.concurrency.max <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip := util.ClientIP(r, ctx.TrustedProxies)
+		if !ctx.concurrency.acquire(ip) {
+			msg := fmt.Sprintf("Too many concurrent requests, maximum of %d is allowed",
+				ctx.concurrency.max)
+			writeJSONError(w, http.StatusTooManyRequests, msg)
+			return
+		}
+		defer ctx.concurrency.release(ip)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitEvictInterval is how often the buckets of client IPs which were
+// idle long enough to refill are deleted
+const rateLimitEvictInterval = 1 * time.Minute
+
+// rateLimiter keeps a token bucket for each client IP.
+type rateLimiter struct {
+	rate      float64
+	burst     float64
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastEvict time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+	return &rateLimiter{
+		rate:      rate,
+		burst:     float64(burst),
+		buckets:   make(map[string]*tokenBucket),
+		lastEvict: time.Now(),
+	}
+}
+
+// allow takes a token from the client's bucket. If the bucket is empty,
+// returns false and the time until the next token is available.
+func (l *rateLimiter) allow(ip string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastEvict) >= rateLimitEvictInterval {
+		l.evict(now)
+	}
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[ip] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.updated).Seconds()*l.rate)
+	b.updated = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// evict deletes the buckets which are full by now, since a new bucket for
+// the same client would be the same
+func (l *rateLimiter) evict(now time.Time) {
+	for ip, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, ip)
+		}
+	}
+	l.lastEvict = now
+}
+
+func limitRate(ctx *HandlerContext, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ctx.rate == nil || ctx.rate.rate <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip := util.ClientIP(r, ctx.TrustedProxies)
+		if ok, wait := ctx.rate.allow(ip, time.Now()); !ok {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			msg := fmt.Sprintf("Too many requests, retry after %d seconds", retryAfter)
+			writeJSONError(w, http.StatusTooManyRequests, msg)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,102 @@
+/*
+* Copyright (C) 2020 Nick Naumenko (https://gitlab.com/nnaumenko)
+* All rights reserved.
+* This software may be modified and distributed under the terms
+* of the MIT license. See the LICENSE file for details.
+ */
+
+package wxserver
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	endpointMetrics string = "metrics"
+
+	defaultMetricsInterval = 5 * time.Minute
+
+	contentTypeMetrics = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// metricsCache keeps the gauges served by metrics endpoint in Prometheus
+// text format. The gauges are recomputed periodically rather than on every
+// scrape, since counting the locations requires scanning the database.
+type metricsCache struct {
+	mu   sync.Mutex
+	text []byte
+}
+
+func metricsInterval(ctx *HandlerContext) time.Duration {
+	if ctx.MetricsInterval == 0 {
+		return defaultMetricsInterval
+	}
+	return ctx.MetricsInterval
+}
+
+// refresh recomputes the gauges; on error the previous gauges are kept.
+func (c *metricsCache) refresh(ctx *HandlerContext) {
+	counts, err := ctx.Db.GetCountryCounts()
+	if err != nil {
+		logger(ctx).Error("Cannot retreive country counts: %s", err.Error())
+		return
+	}
+	countries := make([]string, 0, len(counts))
+	for country := range counts {
+		countries = append(countries, country)
+	}
+	sort.Strings(countries)
+
+	var b bytes.Buffer
+	b.WriteString("# HELP wx_stations_total Number of stored locations per country.\n")
+	b.WriteString("# TYPE wx_stations_total gauge\n")
+	for _, country := range countries {
+		fmt.Fprintf(&b, "wx_stations_total{country=\"%s\"} %d\n",
+			escapeLabelValue(country), counts[country].Locations)
+	}
+	b.WriteString("# HELP wx_reporting_stations Number of locations with current METAR per country.\n")
+	b.WriteString("# TYPE wx_reporting_stations gauge\n")
+	for _, country := range countries {
+		fmt.Fprintf(&b, "wx_reporting_stations{country=\"%s\"} %d\n",
+			escapeLabelValue(country), counts[country].Reporting)
+	}
+
+	c.mu.Lock()
+	c.text = b.Bytes()
+	c.mu.Unlock()
+}
+
+func (c *metricsCache) get() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.text
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabelValue escapes label value as required by Prometheus text
+// format.
+func escapeLabelValue(v string) string {
+	return labelValueReplacer.Replace(v)
+}
+
+// handleMetrics serves per-country coverage gauges in Prometheus text
+// format. Responds with 503 Service Unavailable until the gauges are
+// computed for the first time.
+func handleMetrics(ctx *HandlerContext) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		text := ctx.metrics.get()
+		if text == nil {
+			writeJSONError(w, http.StatusServiceUnavailable, "Metrics are not computed yet")
+			return
+		}
+		w.Header().Set("Content-Type", contentTypeMetrics)
+		w.Write(text)
+	})
+}
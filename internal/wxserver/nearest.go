@@ -0,0 +1,91 @@
+/*
+* Copyright (C) 2020 Nick Naumenko (https://gitlab.com/nnaumenko)
+* All rights reserved.
+* This software may be modified and distributed under the terms
+* of the MIT license. See the LICENSE file for details.
+ */
+
+package wxserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const (
+	paramLatitude  string = "lat"
+	paramLongitude string = "lon"
+	paramCount     string = "count"
+
+	defaultNearestCount = 5
+)
+
+// nearestQuery stores the parameters of nearest locations query.
+type nearestQuery struct {
+	Latitude  float64
+	Longitude float64
+	Count     int
+}
+
+func parseNearestQuery(query string, maxCount int) (nearestQuery, error) {
+	nq := nearestQuery{Count: defaultNearestCount}
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return nq, fmt.Errorf("Unable to parse URL query %s: %s", query, err)
+	}
+	hasLat, hasLon := false, false
+	for k, v := range q {
+		if len(v) != 1 {
+			return nq, fmt.Errorf("Parameter %s must be specified once in URL query %s", k, query)
+		}
+		switch k {
+		case paramLatitude:
+			nq.Latitude, err = strconv.ParseFloat(v[0], 64)
+			if err != nil || nq.Latitude < -90 || nq.Latitude > 90 {
+				return nq, fmt.Errorf("Invalid latitude %s in URL query %s", v[0], query)
+			}
+			hasLat = true
+		case paramLongitude:
+			nq.Longitude, err = strconv.ParseFloat(v[0], 64)
+			if err != nil || nq.Longitude < -180 || nq.Longitude > 180 {
+				return nq, fmt.Errorf("Invalid longitude %s in URL query %s", v[0], query)
+			}
+			hasLon = true
+		case paramCount:
+			nq.Count, err = strconv.Atoi(v[0])
+			if err != nil || nq.Count < 1 || nq.Count > maxCount {
+				return nq, fmt.Errorf("Count %s must be from 1 to %d in URL query %s",
+					v[0], maxCount, query)
+			}
+		default:
+			return nq, fmt.Errorf("Unknown parameter %s in URL query %s", k, query)
+		}
+	}
+	if !hasLat || !hasLon {
+		return nq, fmt.Errorf("Both %s and %s must be specified in URL query %s",
+			paramLatitude, paramLongitude, query)
+	}
+	return nq, nil
+}
+
+// handleNearest serves location info for the locations nearest to the
+// specified coordinates, ordered by distance.
+func handleNearest(ctx *HandlerContext) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nq, err := parseNearestQuery(r.URL.RawQuery, maxLocations(ctx))
+		if err != nil {
+			msg := fmt.Sprintf("Error parsing query: %s", err.Error())
+			writeJSONError(w, http.StatusBadRequest, msg)
+			return
+		}
+		ld, err := ctx.Db.GetNearestLocations(nq.Latitude, nq.Longitude, nq.Count)
+		if err != nil {
+			msg := fmt.Sprintf("Error retreiving nearest locations: %s", err)
+			writeJSONError(w, http.StatusInternalServerError, msg)
+			return
+		}
+		serveJSON(ctx, w, ld)
+	})
+}
@@ -0,0 +1,30 @@
+/*
+* Copyright (C) 2020 Nick Naumenko (https://gitlab.com/nnaumenko)
+* All rights reserved.
+* This software may be modified and distributed under the terms
+* of the MIT license. See the LICENSE file for details.
+ */
+
+package wxserver
+
+import (
+	"net/http"
+
+	"github.com/nnaumenko/wx/internal/util"
+)
+
+const headerRequestID = "X-Request-ID"
+
+// requestID takes request ID from X-Request-ID header or generates a new one
+// if the header is missing or invalid. The ID is stored in the request
+// context and sent in the response header.
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(headerRequestID)
+		if !util.ValidateRequestID(id) {
+			id = util.NewRequestID()
+		}
+		w.Header().Set(headerRequestID, id)
+		next.ServeHTTP(w, r.WithContext(util.ContextWithRequestID(r.Context(), id)))
+	})
+}
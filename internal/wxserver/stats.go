@@ -0,0 +1,71 @@
+/*
+* Copyright (C) 2020 Nick Naumenko (https://gitlab.com/nnaumenko)
+* All rights reserved.
+* This software may be modified and distributed under the terms
+* of the MIT license. See the LICENSE file for details.
+ */
+
+package wxserver
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nnaumenko/wx/pkg/wxtypes"
+)
+
+const (
+	endpointStats string = "stats"
+
+	defaultStatsCacheTTL = 30 * time.Second
+)
+
+// statsCache keeps the stats retreived from the database, since counting
+// the reports requires scanning the keyspace.
+type statsCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	stats   wxtypes.DataStats
+	expires time.Time
+}
+
+func newStatsCache(ttl time.Duration) *statsCache {
+	if ttl == 0 {
+		ttl = defaultStatsCacheTTL
+	}
+	return &statsCache{ttl: ttl}
+}
+
+// get returns the cached stats or, if the cache is expired, retreives the
+// stats from the database.
+func (c *statsCache) get(ctx *HandlerContext) (wxtypes.DataStats, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Now().Before(c.expires) {
+		return c.stats, nil
+	}
+	var s wxtypes.DataStats
+	var err error
+	s.Locations, s.Metars, s.Tafs, err = ctx.Db.GetCounts()
+	if err != nil {
+		return s, err
+	}
+	c.stats, c.expires = s, time.Now().Add(c.ttl)
+	return s, nil
+}
+
+// handleStats serves the number of locations, METARs and TAFs stored in the
+// database, which allows to check that the updater is running.
+func handleStats(ctx *HandlerContext) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := ctx.stats.get(ctx)
+		if err != nil {
+			msg := fmt.Sprintf("Error retreiving stats: %s", err)
+			writeJSONError(w, http.StatusInternalServerError, msg)
+			return
+		}
+		serveJSON(ctx, w, s)
+	})
+}
@@ -0,0 +1,43 @@
+/*
+* Copyright (C) 2020 Nick Naumenko (https://gitlab.com/nnaumenko)
+* All rights reserved.
+* This software may be modified and distributed under the terms
+* of the MIT license. See the LICENSE file for details.
+ */
+
+package wxserver
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/nnaumenko/wx/internal/util"
+)
+
+const endpointTile string = "tile"
+
+// handleTile serves location info for the locations within a geohash cell
+// specified in the path such as /tile/u8c, ordered by ICAO location code.
+func handleTile(ctx *HandlerContext) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if len(p) != 2 || p[0] != endpointTile {
+			msg := fmt.Sprintf("Unable to parse URL path %s", r.URL.Path)
+			writeJSONError(w, http.StatusBadRequest, msg)
+			return
+		}
+		minLat, minLon, maxLat, maxLon, err := util.GeohashBounds(p[1])
+		if err != nil {
+			writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		ld, err := ctx.Db.GetLocationsInBox(minLat, minLon, maxLat, maxLon)
+		if err != nil {
+			msg := fmt.Sprintf("Error retreiving locations for geohash %s: %s", p[1], err)
+			writeJSONError(w, http.StatusInternalServerError, msg)
+			return
+		}
+		serveJSON(ctx, w, ld)
+	})
+}
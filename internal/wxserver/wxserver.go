@@ -8,82 +8,185 @@
 package wxserver
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"net"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/nnaumenko/wx/internal/database"
 	"github.com/nnaumenko/wx/internal/util"
+	"github.com/nnaumenko/wx/pkg/wxtypes"
 )
 
 const (
-	enableCORS   = true
-	maxLocations = 16
-	prettyJSON   = true
+	enableCORS = true
+	prettyJSON = true
+
+	defaultCORSMaxAge   = 600 * time.Second
+	defaultJSONIndent   = "  "
+	defaultMaxLocations = 16
+
+	contentTypeJSON = "application/json; charset=utf-8"
+
+	methodsReadOnly = "GET, HEAD, OPTIONS"
+	methodsPost     = "POST, OPTIONS"
+	methodsQuery    = "GET, HEAD, POST, OPTIONS"
+
+	// maxPostLocations is the maximum number of locations in POST request
+	// body, which is not limited by URL length
+	maxPostLocations = 200
+	maxPostBodyBytes = 64 * 1024
 )
 
+// defaultUnloggedPaths are the paths of health check endpoints polled by
+// orchestrators and metrics endpoint polled by scrapers, not logged unless
+// configured otherwise
+var defaultUnloggedPaths = []string{"/" + endpointHealth, "/healthz", "/readyz", "/" + endpointMetrics}
+
 const (
 	endpointMetar    string = "metar"
 	endpointTaf      string = "taf"
 	endpointLocation string = "location"
 	endpointAll      string = "all"
 
+	endpointDensityAltitude string = "density-altitude"
+	endpointConsistency     string = "consistency"
+	endpointBatch           string = "batch"
+	endpointFull            string = "full"
+	endpointNearest         string = "nearest"
+
 	paramLocation string = "location"
+	paramExclude  string = "exclude"
+	paramSort     string = "sort"
+	paramMaxAge   string = "max_age"
+	paramUnits    string = "units"
+
+	sortICAO string = "icao"
+
+	unitsMetric   string = "metric"
+	unitsImperial string = "imperial"
+
+	fieldMetar string = "metar"
+	fieldTaf   string = "taf"
 
 	helpPath string = "help"
 
 	staticPath string = ""
 )
 
-func logRequest(next http.Handler) http.Handler {
+func logRequest(ctx *HandlerContext, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if containsString(unloggedPaths(ctx), normalisePath(r.URL.Path)) {
+			next.ServeHTTP(w, r)
+			return
+		}
 		start := time.Now()
 		next.ServeHTTP(w, r)
-		log.Println(r.Method, r.URL, time.Now().Sub(start))
+		duration := time.Now().Sub(start)
+		if ctx.SlowRequestThreshold == 0 {
+			logger(ctx).Info("%s%s %s %s %v", util.RequestIDPrefix(r.Context()),
+				util.ClientIP(r, ctx.TrustedProxies), r.Method, r.URL, duration)
+			return
+		}
+		if duration <= ctx.SlowRequestThreshold {
+			logger(ctx).Debug("%s%s %s %s %v", util.RequestIDPrefix(r.Context()),
+				util.ClientIP(r, ctx.TrustedProxies), r.Method, r.URL, duration)
+			return
+		}
+		logger(ctx).Warn("%sslow request %s %s %s %v %s %s",
+			util.RequestIDPrefix(r.Context()), util.ClientIP(r, ctx.TrustedProxies), r.Method, r.URL, duration,
+			r.Proto, r.Header.Get("User-Agent"))
 	})
 }
 
-func checkMethod(next http.Handler) http.Handler {
+// normalisePath removes trailing slash from the path, except root path
+func normalisePath(path string) string {
+	if len(path) > 1 {
+		return strings.TrimRight(path, "/")
+	}
+	return path
+}
+
+func logger(ctx *HandlerContext) util.Logger {
+	return util.LoggerOrDefault(ctx.Log)
+}
+
+func unloggedPaths(ctx *HandlerContext) []string {
+	if ctx.UnloggedPaths == nil {
+		return defaultUnloggedPaths
+	}
+	return ctx.UnloggedPaths
+}
+
+// checkMethod serves OPTIONS requests and rejects the requests with
+// methods not included in comma-separated list of allowed methods
+func checkMethod(ctx *HandlerContext, methods string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			next.ServeHTTP(w, r)
-		case http.MethodHead:
+		switch {
+		case r.Method == http.MethodOptions:
+			util.ServeOptions(w, r, methods, enableCORS, ctx.AllowedOrigins, corsMaxAge(ctx))
+		case methodAllowed(methods, r.Method):
 			next.ServeHTTP(w, r)
-		case http.MethodOptions:
-			util.ServeOptions(w, r, true, enableCORS)
 		default:
-			w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+			w.Header().Set("Allow", methods)
 			msg := fmt.Sprintf("Method %s is not allowed", r.Method)
-			http.Error(w, msg, http.StatusMethodNotAllowed)
+			writeJSONError(w, http.StatusMethodNotAllowed, msg)
 		}
 	})
 }
 
-func addCorsHeaders(next http.Handler) http.Handler {
+func methodAllowed(methods string, method string) bool {
+	for _, m := range strings.Split(methods, ",") {
+		if strings.TrimSpace(m) == method {
+			return true
+		}
+	}
+	return false
+}
+
+func maxLocations(ctx *HandlerContext) int {
+	if ctx.MaxLocations == 0 {
+		return defaultMaxLocations
+	}
+	return ctx.MaxLocations
+}
+
+func corsMaxAge(ctx *HandlerContext) time.Duration {
+	if ctx.CORSMaxAge == 0 {
+		return defaultCORSMaxAge
+	}
+	return ctx.CORSMaxAge
+}
+
+func addCorsHeaders(ctx *HandlerContext, methods string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if enableCORS {
-			util.SetCORSHeaders(w)
+			util.SetCORSHeaders(w, r, methods, ctx.AllowedOrigins)
 		}
 		next.ServeHTTP(w, r)
 	})
 }
 
 func serveStaticFile(w http.ResponseWriter, path string, contentType string) {
+	// Headers must be set before the file is written; writeJSONError below
+	// overrides Content-Type if the file cannot be read
+	if len(contentType) > 0 {
+		w.Header().Set("Content-Type", contentType)
+	}
 	err := util.ServeStaticFile(w, path)
 	if err != nil {
 		msg := fmt.Sprintf("Error serving file %s: %s", path, err.Error())
-		http.Error(w, msg, http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, msg)
 		return
 	}
-	if len(contentType) > 0 {
-		w.Header().Set("Content-Type", contentType)
-	}
 }
 
 func handleStaticPaths() http.Handler {
@@ -95,9 +198,13 @@ func handleStaticPaths() http.Handler {
 			serveStaticFile(w, staticPath+"help.html", "text/html; charset=utf-8")
 		case "/help/":
 			serveStaticFile(w, staticPath+"help.html", "text/html; charset=utf-8")
+		case "/favicon.ico":
+			serveStaticFile(w, staticPath+"favicon.ico", "image/x-icon")
+		case "/robots.txt":
+			serveStaticFile(w, staticPath+"robots.txt", "text/plain; charset=utf-8")
 		default:
 			msg := fmt.Sprintf("Unknown endpoint or path %s", r.URL.Path)
-			http.Error(w, msg, http.StatusForbidden)
+			writeJSONError(w, http.StatusNotFound, msg)
 		}
 	})
 }
@@ -124,6 +231,43 @@ func parsePath(path string) (string, string, error) {
 // QueryParameters stores the parameters submitted in the URL query.
 type QueryParameters struct {
 	Locations []string
+	Exclude   []string
+	Sort      string
+	Format    string
+	// Fields, if specified, are the only fields served
+	Fields []string
+	// MaxAge, if specified, is the maximum age of METAR observation; older
+	// METARs are not served
+	MaxAge time.Duration
+	// Units, if specified, selects metric or imperial altitude field; both
+	// are served otherwise
+	Units string
+}
+
+// excludableFields lists the response fields which can be omitted with
+// exclude parameter.
+var excludableFields = []string{fieldMetar, fieldTaf}
+
+// parseFieldList parses a list of response field names specified in a URL
+// query and validates each field name against the known ones.
+func parseFieldList(queryValues []string, known []string) ([]string, error) {
+	fields := util.ParseURLQueryList(queryValues)
+	for i := 0; i < len(fields); i++ {
+		fields[i] = strings.ToLower(fields[i])
+		if !containsString(known, fields[i]) {
+			return fields, fmt.Errorf("Unknown field %s", fields[i])
+		}
+	}
+	return fields, nil
+}
+
+func containsString(s []string, str string) bool {
+	for _, v := range s {
+		if v == str {
+			return true
+		}
+	}
+	return false
 }
 
 func parseQuery(query string) (QueryParameters, error) {
@@ -132,132 +276,545 @@ func parseQuery(query string) (QueryParameters, error) {
 	if err != nil {
 		return qp, fmt.Errorf("Unable to parse URL query %s: %s", query, err)
 	}
-	for k, v := range q {
+	// Parameters are parsed in sorted order so that the error is the same
+	// for the same query
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var unknown []string
+	for _, k := range keys {
+		v := q[k]
 		switch k {
 		case paramLocation:
 			locations := util.ParseURLQueryList(v)
-			for i := 0; i < len(locations); i++ {
-				locations[i] = strings.ToUpper(locations[i])
+			for _, l := range locations {
+				if len(strings.TrimSpace(l)) == 0 {
+					return qp, fmt.Errorf("Empty location in URL query %s", query)
+				}
+			}
+			qp.Locations = uniqueLocations(locations)
+
+		case paramExclude:
+			exclude, err := parseFieldList(v, excludableFields)
+			if err != nil {
+				return qp, fmt.Errorf("%s in URL query %s", err, query)
+			}
+			qp.Exclude = exclude
+
+		case paramSort:
+			if len(v) != 1 || strings.ToLower(v[0]) != sortICAO {
+				return qp, fmt.Errorf("Unknown sort order %v in URL query %s", v, query)
+			}
+			qp.Sort = sortICAO
+
+		case paramFields:
+			fields, err := parseFieldList(v, selectableFields)
+			if err != nil {
+				return qp, fmt.Errorf("%s in URL query %s", err, query)
+			}
+			qp.Fields = fields
+
+		case paramMaxAge:
+			if len(v) != 1 {
+				return qp, fmt.Errorf("Maximum age must be specified once in URL query %s", query)
+			}
+			seconds, err := strconv.Atoi(v[0])
+			if err != nil || seconds <= 0 {
+				return qp, fmt.Errorf("Invalid maximum age %s in URL query %s, "+
+					"must be positive number of seconds", v[0], query)
+			}
+			qp.MaxAge = time.Duration(seconds) * time.Second
+
+		case paramUnits:
+			if len(v) != 1 {
+				return qp, fmt.Errorf("Units must be specified once in URL query %s", query)
+			}
+			units := strings.ToLower(v[0])
+			if units != unitsMetric && units != unitsImperial {
+				return qp, fmt.Errorf("Unknown units %s in URL query %s", v[0], query)
 			}
-			qp.Locations = locations
+			qp.Units = units
+
+		case paramFormat:
+			if len(v) != 1 {
+				return qp, fmt.Errorf("Format must be specified once in URL query %s", query)
+			}
+			qp.Format = strings.ToLower(v[0])
 
 		default:
-			return qp, fmt.Errorf("Unknown parameter %s in URL query %s", k, query)
+			unknown = append(unknown, k)
 		}
 	}
+	switch len(unknown) {
+	case 0:
+	case 1:
+		return qp, fmt.Errorf("Unknown parameter %s in URL query %s", unknown[0], query)
+	default:
+		return qp, fmt.Errorf("Unknown parameters %s in URL query %s",
+			strings.Join(unknown, ", "), query)
+	}
 	return qp, nil
 }
 
+// uniqueLocations converts location codes to uppercase and removes repeated
+// locations, keeping the order in which the locations are first specified.
+func uniqueLocations(locations []string) []string {
+	result := make([]string, 0, len(locations))
+	seen := make(map[string]bool, len(locations))
+	for _, l := range locations {
+		l = strings.ToUpper(l)
+		if seen[l] {
+			continue
+		}
+		seen[l] = true
+		result = append(result, l)
+	}
+	return result
+}
+
+// defaultLocations converts DefaultLocations to uppercase and removes
+// repeated locations. Invalid locations and the locations exceeding
+// MaxLocations are logged and not served, so that the requests without
+// location are not rejected for the reason the client cannot fix.
+func defaultLocations(ctx *HandlerContext) []string {
+	var result []string
+	for _, l := range uniqueLocations(ctx.DefaultLocations) {
+		if !util.ValidateICAOLocation(l) {
+			logger(ctx).Error("Invalid default location %s is ignored", l)
+			continue
+		}
+		result = append(result, l)
+	}
+	if max := maxLocations(ctx); len(result) > max {
+		logger(ctx).Error("%d default locations specified while maximum of %d is allowed, "+
+			"locations %v are ignored", len(result), max, result[max:])
+		result = result[:max]
+	}
+	return result
+}
+
+// NoDataMode specifies the response for a single location which exists in
+// the database but has no data for the requested endpoint (e.g. no current
+// METAR).
+type NoDataMode int
+
+const (
+	// NoDataFlag responds with an object containing location and no_data
+	// field set to true
+	NoDataFlag NoDataMode = iota
+	// NoDataNoContent responds with 204 No Content and without body
+	NoDataNoContent
+)
+
+// ExpiredTafMode specifies how TAFs are served after the end of validity
+// period, until they are removed from the database.
+type ExpiredTafMode int
+
+const (
+	// ExpiredTafDrop serves expired TAFs as absent
+	ExpiredTafDrop ExpiredTafMode = iota
+	// ExpiredTafFlag serves expired TAFs with taf_expired field set to true
+	ExpiredTafFlag
+)
+
+// TafAgeBasis specifies the time which TAF age and freshness are computed
+// from.
+type TafAgeBasis int
+
+const (
+	// TafAgeValidity computes TAF age from the start of validity period;
+	// TAF expires at the end of validity period
+	TafAgeValidity TafAgeBasis = iota
+	// TafAgeIssue computes TAF age from issue time; TAF expires when its
+	// age exceeds MaxTafIssueAge
+	TafAgeIssue
+)
+
+const defaultMaxTafIssueAge = 6 * time.Hour
+
 // HandlerContext is passed to endpoint handlers
 type HandlerContext struct {
-	Db  database.Database
-	Log log.Logger
+	Db database.Database
+	// Log is the logger for requests and errors; util.StdLogger is used if
+	// nil
+	Log    util.Logger
+	NoData NoDataMode
+	// ExpiredTafs specifies how TAFs are served after the end of validity
+	// period
+	ExpiredTafs ExpiredTafMode
+	// TafAge specifies whether TAF age and freshness are computed from
+	// validity period or issue time
+	TafAge TafAgeBasis
+	// MaxTafIssueAge is the age after which TAF expires if TafAge is
+	// TafAgeIssue; defaults to 6 hours if zero
+	MaxTafIssueAge time.Duration
+	// TrustedProxies are the networks of reverse proxies or load balancers
+	// allowed to specify client IP via X-Forwarded-For or X-Real-IP headers
+	TrustedProxies []*net.IPNet
+	// CORSMaxAge is how long browsers may cache the result of CORS
+	// preflight request; defaults to 10 minutes if zero
+	CORSMaxAge time.Duration
+	// AllowedOrigins are the origins allowed to make CORS requests, such as
+	// "https://example.com"; any origin is allowed if empty
+	AllowedOrigins []string
+	// SlowRequestThreshold, if not zero, makes the requests which took
+	// longer than the threshold logged as warnings and the rest of the
+	// requests logged as debug messages
+	SlowRequestThreshold time.Duration
+	// DefaultLocations, if specified, are served when the request does not
+	// specify any location; converted to uppercase and checked the same
+	// way as the locations specified in the request
+	DefaultLocations []string
+	// MaxConcurrentPerIP limits the number of requests served concurrently
+	// for a single client IP; zero means no limit
+	MaxConcurrentPerIP int
+	// JSONIndent is the indent used in pretty-printed JSON responses;
+	// defaults to two spaces if empty
+	JSONIndent string
+	// UnloggedPaths are the request paths (without trailing slash) excluded
+	// from request logging;
+	// defaults to health check paths /health, /healthz, /readyz and metrics
+	// path /metrics if nil,
+	// an empty non-nil slice enables logging of all requests
+	UnloggedPaths []string
+	// MaxLocations is the maximum number of locations in URL query;
+	// defaults to 16 if zero
+	MaxLocations int
+	// MaxFullLocations is the maximum number of locations in URL query of
+	// full endpoint, which decodes every METAR; defaults to 4 if zero
+	MaxFullLocations int
+	// RateLimit is the sustained number of requests per second allowed for
+	// a single client IP; zero means no limit
+	RateLimit float64
+	// RateLimitBurst is the number of requests a client IP may make at once
+	// before RateLimit applies; defaults to RateLimit rounded up if zero
+	RateLimitBurst int
+	// StatsCacheTTL is how long the stats served by stats endpoint are
+	// cached; defaults to 30 seconds if zero
+	StatsCacheTTL time.Duration
+	// DataEpochCacheTTL is how long the data epoch served in X-Data-Epoch
+	// header is cached; defaults to 30 seconds if zero
+	DataEpochCacheTTL time.Duration
+	// MetricsInterval is how often the gauges served by metrics endpoint
+	// are recomputed; defaults to 5 minutes if zero. Setting it to the
+	// interval of METAR updates or longer avoids recomputing the gauges for
+	// unchanged data.
+	MetricsInterval time.Duration
+
+	concurrency *concurrencyLimiter
+	rate        *rateLimiter
+	stats       *statsCache
+	epoch       *epochCache
+	metrics     *metricsCache
+	// defaultLocations are normalised DefaultLocations
+	defaultLocations []string
 }
 
-func queryDatabase(ctx *HandlerContext, endpoint string, locations []string) ([]*database.DataICAOLocation, error) {
+func queryDatabase(ctx *HandlerContext, reqCtx context.Context, endpoint string, locations []string, qparam QueryParameters) ([]*wxtypes.DataICAOLocation, error) {
+	excludeMetar := containsString(qparam.Exclude, fieldMetar)
+	excludeTaf := containsString(qparam.Exclude, fieldTaf)
+	var ld []*wxtypes.DataICAOLocation
+	var err error
 	switch endpoint {
 	case endpointMetar:
-		return ctx.Db.GetMETARs(locations)
+		ld, err = ctx.Db.GetMETARsContext(reqCtx, locations)
 	case endpointTaf:
-		return ctx.Db.GetTAFs(locations)
+		ld, err = ctx.Db.GetTAFsContext(reqCtx, locations)
 	case endpointLocation:
-		ld, err := ctx.Db.GetICAOLocationData(locations)
-		if err != nil {
-			return make([]*database.DataICAOLocation, 0), err
+		ld, err = ctx.Db.GetLocationInfoContext(reqCtx, locations)
+	case endpointAll:
+		if excludeMetar && excludeTaf {
+			// No need to retreive reports if both are omitted anyway
+			ld, err = ctx.Db.GetLocationInfoContext(reqCtx, locations)
+		} else {
+			ld, err = ctx.Db.GetICAOLocationDataContext(reqCtx, locations)
 		}
-		for i := 0; i < len(ld); i++ {
+	default:
+		err = fmt.Errorf("Unknown Endpoint %s", endpoint)
+	}
+	if err != nil {
+		return make([]*wxtypes.DataICAOLocation, 0), err
+	}
+	now := time.Now()
+	for i := 0; i < len(ld); i++ {
+		wxtypes.SplitMetarType(ld[i])
+		if excludeMetar || metarTooOld(ld[i], qparam.MaxAge, now) {
 			ld[i].Metar = ""
+			ld[i].MetarObservationTime = ""
+			ld[i].MetarType = ""
+		}
+		expired := tafExpired(ctx, ld[i], now)
+		if excludeTaf || (expired && ctx.ExpiredTafs == ExpiredTafDrop) {
 			ld[i].Taf = ""
+			ld[i].TafValidFrom = ""
+			ld[i].TafValidTo = ""
+			ld[i].TafIssueTime = ""
+		} else if len(ld[i].Taf) > 0 {
+			ld[i].TafAgeBasis = tafAgeBasisName(ctx.TafAge)
+			ld[i].TafAgeSeconds = tafAge(ctx, ld[i], now)
+			ld[i].TafExpired = expired
 		}
-		return ld, err
-	case endpointAll:
-		return ctx.Db.GetICAOLocationData(locations)
-	default:
-		err := fmt.Errorf("Unknown Endpoint %s", endpoint)
-		return make([]*database.DataICAOLocation, 0), err
+		switch qparam.Units {
+		case unitsMetric:
+			ld[i].AltitudeFeet = 0
+		case unitsImperial:
+			ld[i].AltitudeMeters = 0
+		}
+	}
+	if endpoint == endpointMetar && qparam.MaxAge > 0 {
+		// Locations without current METAR are not served by metar endpoint
+		current := ld[:0]
+		for _, l := range ld {
+			if len(l.Metar) > 0 {
+				current = append(current, l)
+			}
+		}
+		ld = current
+	}
+	if endpoint == endpointTaf {
+		// Locations without current TAF are not served by taf endpoint
+		current := ld[:0]
+		for _, l := range ld {
+			if len(l.Taf) > 0 {
+				current = append(current, l)
+			}
+		}
+		ld = current
+	}
+	return ld, nil
+}
+
+// tafExpired checks whether TAF validity period is over or, if TAF age is
+// computed from issue time, whether TAF is older than MaxTafIssueAge. TAF
+// with unknown end of validity period or issue time is not considered
+// expired.
+func tafExpired(ctx *HandlerContext, ld *wxtypes.DataICAOLocation, now time.Time) bool {
+	if len(ld.Taf) == 0 {
+		return false
+	}
+	if ctx.TafAge == TafAgeIssue {
+		issued, ok := parseTafTime(ld.TafIssueTime)
+		return ok && now.Sub(issued) > maxTafIssueAge(ctx)
+	}
+	validTo, ok := parseTafTime(ld.TafValidTo)
+	return ok && !now.Before(validTo)
+}
+
+// tafAge returns the number of seconds elapsed since TAF issue time or the
+// start of validity period, depending on TafAge; nil if the time is not
+// known.
+func tafAge(ctx *HandlerContext, ld *wxtypes.DataICAOLocation, now time.Time) *int64 {
+	from := ld.TafValidFrom
+	if ctx.TafAge == TafAgeIssue {
+		from = ld.TafIssueTime
+	}
+	t, ok := parseTafTime(from)
+	if !ok {
+		return nil
 	}
+	age := int64(now.Sub(t) / time.Second)
+	return &age
+}
+
+// parseTafTime parses TAF time in RFC3339 format; returns false if the time
+// is not known or invalid.
+func parseTafTime(s string) (time.Time, bool) {
+	if len(s) == 0 {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	return t, err == nil
+}
+
+func tafAgeBasisName(b TafAgeBasis) string {
+	if b == TafAgeIssue {
+		return wxtypes.TafAgeBasisIssue
+	}
+	return wxtypes.TafAgeBasisValidity
+}
+
+func maxTafIssueAge(ctx *HandlerContext) time.Duration {
+	if ctx.MaxTafIssueAge == 0 {
+		return defaultMaxTafIssueAge
+	}
+	return ctx.MaxTafIssueAge
+}
+
+// metarTooOld checks whether METAR observation was taken more than maxAge
+// ago. METAR with unknown observation time is not considered too old.
+func metarTooOld(ld *wxtypes.DataICAOLocation, maxAge time.Duration, now time.Time) bool {
+	if maxAge <= 0 || len(ld.MetarObservationTime) == 0 {
+		return false
+	}
+	obsTime, err := time.Parse(time.RFC3339, ld.MetarObservationTime)
+	if err != nil {
+		return false
+	}
+	return now.Sub(obsTime) > maxAge
+}
+
+func serveJSON(ctx *HandlerContext, w http.ResponseWriter, v interface{}) {
+	var j []byte
+	var err error
+	if prettyJSON {
+		j, err = json.MarshalIndent(v, "", jsonIndent(ctx))
+	} else {
+		j, err = json.Marshal(v)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("Error converting to JSON: %s", err)
+		writeJSONError(w, http.StatusInternalServerError, msg)
+		return
+	}
+	setDataEpochHeader(ctx, w)
+	w.Header().Set("Content-Type", contentTypeJSON)
+	fmt.Fprintf(w, "%s\n", j)
+}
+
+// writeJSONError responds with wxtypes.ErrorResponse holding the message and
+// HTTP status code. Used instead of http.Error so that the clients receive
+// JSON for both data and errors.
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	j, err := json.Marshal(wxtypes.ErrorResponse{Error: message, Status: status})
+	if err != nil {
+		http.Error(w, message, status)
+		return
+	}
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", contentTypeJSON)
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s\n", j)
+}
+
+// jsonIndent is also used for XML responses
+func jsonIndent(ctx *HandlerContext) string {
+	if len(ctx.JSONIndent) == 0 {
+		return defaultJSONIndent
+	}
+	return ctx.JSONIndent
+}
+
+const defaultDataEpochCacheTTL = 30 * time.Second
 
+// epochCache keeps the data epoch retreived from the database, so that
+// serving the epoch with every response does not require an extra database
+// round trip per request.
+type epochCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	epoch   int64
+	expires time.Time
 }
 
-func serveMultipleLocations(ctx *HandlerContext, w http.ResponseWriter, endpoint string, qparam QueryParameters) {
-	if len(qparam.Locations) > maxLocations {
+func newEpochCache(ttl time.Duration) *epochCache {
+	if ttl == 0 {
+		ttl = defaultDataEpochCacheTTL
+	}
+	return &epochCache{ttl: ttl}
+}
+
+// get returns the cached data epoch or, if the cache is expired, retreives
+// the data epoch from the database.
+func (c *epochCache) get(ctx *HandlerContext) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Now().Before(c.expires) {
+		return c.epoch, nil
+	}
+	epoch, err := ctx.Db.GetDataEpoch()
+	if err != nil {
+		return 0, err
+	}
+	c.epoch, c.expires = epoch, time.Now().Add(c.ttl)
+	return epoch, nil
+}
+
+func setDataEpochHeader(ctx *HandlerContext, w http.ResponseWriter) {
+	if epoch, err := ctx.epoch.get(ctx); err != nil {
+		logger(ctx).Error("Cannot retreive data epoch: %s", err.Error())
+	} else {
+		w.Header().Set("X-Data-Epoch", strconv.FormatInt(epoch, 10))
+	}
+}
+
+func serveMultipleLocations(ctx *HandlerContext, w http.ResponseWriter, r *http.Request, endpoint string, qparam QueryParameters, max int) {
+	if len(qparam.Locations) > max {
 		msg := fmt.Sprintf("%d location specified while maximum of %d is allowed",
-			len(qparam.Locations), maxLocations)
-		http.Error(w, msg, http.StatusForbidden)
+			len(qparam.Locations), max)
+		writeJSONError(w, http.StatusForbidden, msg)
 		return
 	}
 	for _, l := range qparam.Locations {
 		if !util.ValidateICAOLocation(l) {
 			msg := fmt.Sprintf("Invalid ICAO location code format %s", l)
-			http.Error(w, msg, http.StatusUnprocessableEntity)
+			writeJSONError(w, http.StatusUnprocessableEntity, msg)
 			return
 		}
 	}
-	ld, err := queryDatabase(ctx, endpoint, qparam.Locations)
+	ld, err := queryDatabase(ctx, r.Context(), endpoint, qparam.Locations, qparam)
 	if err != nil {
 		msg := fmt.Sprintf("Error retreiving data for locations %v: %s", qparam.Locations, err)
-		http.Error(w, msg, http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, msg)
 		return
 	}
-	var j []byte
-	if prettyJSON {
-		j, err = json.MarshalIndent(ld, "", "  ")
-	} else {
-		j, err = json.Marshal(ld)
-	}
-	if err != nil {
-		msg := fmt.Sprintf("Error converting to JSON: %s", err)
-		http.Error(w, msg, http.StatusInternalServerError)
-		return
+	if qparam.Sort == sortICAO {
+		sort.SliceStable(ld, func(i, j int) bool {
+			return ld[i].Location < ld[j].Location
+		})
 	}
-	w.Header().Set("Content-Type", "application-json")
-	fmt.Fprintf(w, "%s\n", j)
+	serveLocations(ctx, w, r, endpoint, qparam, ld)
 }
 
-func serveSingleLocation(ctx *HandlerContext, w http.ResponseWriter, endpoint string, location string, qparam QueryParameters) {
+func serveSingleLocation(ctx *HandlerContext, w http.ResponseWriter, r *http.Request, endpoint string, location string, qparam QueryParameters) {
 	if !util.ValidateICAOLocation(location) {
 		msg := fmt.Sprintf("Invalid ICAO location code format %s", location)
-		http.Error(w, msg, http.StatusUnprocessableEntity)
+		writeJSONError(w, http.StatusUnprocessableEntity, msg)
 		return
 	}
-	ld, err := queryDatabase(ctx, endpoint, []string{location})
+	ld, err := queryDatabase(ctx, r.Context(), endpoint, []string{location}, qparam)
 	if err != nil {
 		msg := fmt.Sprintf("Error retreiving data for location %s: %s", location, err)
-		http.Error(w, msg, http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, msg)
 		return
 	}
 	if len(ld) < 1 {
-		exists, err := ctx.Db.LocationExists(location)
+		info, err := ctx.Db.GetLocationInfoContext(r.Context(), []string{location})
 		if err != nil {
 			msg := fmt.Sprintf("Error checking location existence %s: %s", location, err)
-			http.Error(w, msg, http.StatusInternalServerError)
+			writeJSONError(w, http.StatusInternalServerError, msg)
 			return
 		}
-		if !exists {
+		if len(info) < 1 {
 			msg := fmt.Sprintf("Location %s is not found", location)
-			http.Error(w, msg, http.StatusNotFound)
+			writeJSONError(w, http.StatusNotFound, msg)
+			return
+		}
+		if info[0].Closed {
+			msg := fmt.Sprintf("Location %s is closed", location)
+			writeJSONError(w, http.StatusGone, msg)
 			return
 		}
-		ld = append(ld, &database.DataICAOLocation{Location: location})
+		if ctx.NoData == NoDataNoContent {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		ld = append(ld, &wxtypes.DataICAOLocation{Location: location, NoData: true})
 	}
 	if len(ld) > 1 {
 		msg := fmt.Sprintf("Inconsistent data for ICAO location %s: %v", location, ld)
-		http.Error(w, msg, http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, msg)
 		return
 	}
-	var j []byte
-	if prettyJSON {
-		j, err = json.MarshalIndent(ld[0], "", "  ")
-	} else {
-		j, err = json.Marshal(ld[0])
-	}
-	if err != nil {
-		msg := fmt.Sprintf("Error converting to JSON: %s", err)
-		http.Error(w, msg, http.StatusInternalServerError)
+	if ld[0].Closed {
+		msg := fmt.Sprintf("Location %s is closed", location)
+		writeJSONError(w, http.StatusGone, msg)
 		return
 	}
-	w.Header().Set("Content-Type", "application-json")
-	fmt.Fprintf(w, "%s\n", j)
+	serveLocations(ctx, w, r, endpoint, qparam, ld[0])
 }
 
 func handleEndpoints(ctx *HandlerContext) http.Handler {
@@ -265,51 +822,116 @@ func handleEndpoints(ctx *HandlerContext) http.Handler {
 		endpoint, locationSingle, err := parsePath(r.URL.Path)
 		if err != nil {
 			msg := fmt.Sprintf("Error parsing path: %s", err.Error())
-			http.Error(w, msg, http.StatusBadRequest)
+			writeJSONError(w, http.StatusBadRequest, msg)
 			return
 		}
 		queryParam, err := parseQuery(r.URL.RawQuery)
 		if err != nil {
 			msg := fmt.Sprintf("Error parsing query: %s", err.Error())
-			http.Error(w, msg, http.StatusBadRequest)
+			writeJSONError(w, http.StatusBadRequest, msg)
+			return
+		}
+		if f := queryParam.Format; len(f) > 0 && f != formatJSON && f != formatXML && f != formatCSV {
+			msg := fmt.Sprintf("Unsupported format %s, json, xml or csv is allowed", f)
+			writeJSONError(w, http.StatusNotAcceptable, msg)
+			return
+		}
+		if r.Method == http.MethodPost {
+			if len(queryParam.Locations) > 0 || len(locationSingle) > 0 {
+				writeJSONError(w, http.StatusUnprocessableEntity,
+					"Locations must be specified in POST request body only")
+				return
+			}
+			var req wxtypes.LocationsRequest
+			body := http.MaxBytesReader(w, r.Body, maxPostBodyBytes)
+			if err := json.NewDecoder(body).Decode(&req); err != nil {
+				msg := fmt.Sprintf("Error parsing request body: %s", err.Error())
+				writeJSONError(w, http.StatusBadRequest, msg)
+				return
+			}
+			if len(req.Locations) == 0 {
+				writeJSONError(w, http.StatusUnprocessableEntity, "Location not specified")
+				return
+			}
+			queryParam.Locations = uniqueLocations(req.Locations)
+			serveMultipleLocations(ctx, w, r, endpoint, queryParam, maxPostLocations)
 			return
 		}
 		switch {
 		case len(queryParam.Locations) > 0 && len(locationSingle) == 0:
-			serveMultipleLocations(ctx, w, endpoint, queryParam)
+			serveMultipleLocations(ctx, w, r, endpoint, queryParam, maxLocations(ctx))
 		case len(queryParam.Locations) == 0 && len(locationSingle) > 0:
-			serveSingleLocation(ctx, w, endpoint, locationSingle, queryParam)
+			serveSingleLocation(ctx, w, r, endpoint, locationSingle, queryParam)
 		case len(queryParam.Locations) == 0 && len(locationSingle) == 0:
-			http.Error(w, "Location not specified",
-				http.StatusUnprocessableEntity)
-			return
+			if len(ctx.defaultLocations) == 0 {
+				writeJSONError(w, http.StatusUnprocessableEntity, "Location not specified")
+				return
+			}
+			queryParam.Locations = append([]string{}, ctx.defaultLocations...)
+			serveMultipleLocations(ctx, w, r, endpoint, queryParam, maxLocations(ctx))
 		default:
 			msg := fmt.Sprintf(
 				"Single location %s and multiple locations %v "+
 					"must not be specified in the same request",
 				locationSingle, queryParam.Locations)
-			http.Error(w, msg, http.StatusUnprocessableEntity)
+			writeJSONError(w, http.StatusUnprocessableEntity, msg)
 			return
 		}
 	})
 }
 
-func middleware(next http.Handler) http.Handler {
-	return logRequest(checkMethod(addCorsHeaders(next)))
+func middlewareMethods(ctx *HandlerContext, methods string, next http.Handler) http.Handler {
+	return requestID(logRequest(ctx, limitRate(ctx, limitConcurrency(ctx, compressResponse(
+		conditionalResponse(checkMethod(ctx, methods, addCorsHeaders(ctx, methods, next))))))))
+}
+
+func middleware(ctx *HandlerContext, next http.Handler) http.Handler {
+	return middlewareMethods(ctx, methodsReadOnly, next)
+}
+
+func middlewarePost(ctx *HandlerContext, next http.Handler) http.Handler {
+	return middlewareMethods(ctx, methodsPost, next)
+}
+
+// middlewareQuery is used for the endpoints which accept locations either in
+// URL query or in POST request body
+func middlewareQuery(ctx *HandlerContext, next http.Handler) http.Handler {
+	return middlewareMethods(ctx, methodsQuery, next)
 }
 
 // SetupHandlers adds handlers to mux
 func SetupHandlers(mux *http.ServeMux, ctx *HandlerContext) {
-	mux.Handle("/", middleware(handleStaticPaths()))
-	mux.Handle("/"+helpPath+"/", middleware(handleStaticPaths()))
-	mux.Handle("/"+helpPath, middleware(handleStaticPaths()))
-
-	mux.Handle("/"+endpointMetar+"/", middleware(handleEndpoints(ctx)))
-	mux.Handle("/"+endpointTaf+"/", middleware(handleEndpoints(ctx)))
-	mux.Handle("/"+endpointLocation+"/", middleware(handleEndpoints(ctx)))
-	mux.Handle("/"+endpointAll+"/", middleware(handleEndpoints(ctx)))
-	mux.Handle("/"+endpointMetar, middleware(handleEndpoints(ctx)))
-	mux.Handle("/"+endpointTaf, middleware(handleEndpoints(ctx)))
-	mux.Handle("/"+endpointLocation, middleware(handleEndpoints(ctx)))
-	mux.Handle("/"+endpointAll, middleware(handleEndpoints(ctx)))
+	ctx.concurrency = newConcurrencyLimiter(ctx.MaxConcurrentPerIP)
+	ctx.rate = newRateLimiter(ctx.RateLimit, ctx.RateLimitBurst)
+	ctx.stats = newStatsCache(ctx.StatsCacheTTL)
+	ctx.epoch = newEpochCache(ctx.DataEpochCacheTTL)
+	ctx.metrics = &metricsCache{}
+	ctx.defaultLocations = defaultLocations(ctx)
+	util.Schedule(func() { ctx.metrics.refresh(ctx) }, metricsInterval(ctx))
+
+	mux.Handle("/", middleware(ctx, handleStaticPaths()))
+	mux.Handle("/"+helpPath+"/", middleware(ctx, handleStaticPaths()))
+	mux.Handle("/"+helpPath, middleware(ctx, handleStaticPaths()))
+
+	mux.Handle("/"+endpointHealth, middlewareHealth(ctx, handleHealth(ctx)))
+	mux.Handle("/"+endpointMetrics, middlewareHealth(ctx, handleMetrics(ctx)))
+
+	mux.Handle("/"+endpointBatch, middlewarePost(ctx, handleBatch(ctx)))
+	mux.Handle("/"+endpointDensityAltitude+"/", middleware(ctx, handleDensityAltitude(ctx)))
+	mux.Handle("/"+endpointConsistency+"/", middleware(ctx, handleConsistency(ctx)))
+	mux.Handle("/"+endpointFull+"/", middleware(ctx, handleFull(ctx)))
+	mux.Handle("/"+endpointFull, middleware(ctx, handleFull(ctx)))
+	mux.Handle("/"+endpointNearest, middleware(ctx, handleNearest(ctx)))
+	mux.Handle("/"+endpointTile+"/", middleware(ctx, handleTile(ctx)))
+	mux.Handle("/"+endpointStats, middleware(ctx, handleStats(ctx)))
+	mux.Handle("/"+endpointDecode+"/", middleware(ctx, handleDecode(ctx)))
+
+	mux.Handle("/"+endpointMetar+"/", middlewareQuery(ctx, handleEndpoints(ctx)))
+	mux.Handle("/"+endpointTaf+"/", middlewareQuery(ctx, handleEndpoints(ctx)))
+	mux.Handle("/"+endpointLocation+"/", middlewareQuery(ctx, handleEndpoints(ctx)))
+	mux.Handle("/"+endpointAll+"/", middlewareQuery(ctx, handleEndpoints(ctx)))
+	mux.Handle("/"+endpointMetar, middlewareQuery(ctx, handleEndpoints(ctx)))
+	mux.Handle("/"+endpointTaf, middlewareQuery(ctx, handleEndpoints(ctx)))
+	mux.Handle("/"+endpointLocation, middlewareQuery(ctx, handleEndpoints(ctx)))
+	mux.Handle("/"+endpointAll, middlewareQuery(ctx, handleEndpoints(ctx)))
 }
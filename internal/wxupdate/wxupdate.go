@@ -8,34 +8,46 @@
 package wxupdate
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
-	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/nnaumenko/wx/internal/database"
 	"github.com/nnaumenko/wx/internal/util"
+	"github.com/nnaumenko/wx/pkg/wxtypes"
 )
 
 const (
-	avcMetarURL string = "https://www.aviationweather.gov/adds/dataserver_current/current/metars.cache.csv"
-	avcTafURL   string = "https://www.aviationweather.gov/adds/dataserver_current/current/tafs.cache.csv"
+	defaultMetarURL string = "https://www.aviationweather.gov/adds/dataserver_current/current/metars.cache.csv"
+	defaultTafURL   string = "https://www.aviationweather.gov/adds/dataserver_current/current/tafs.cache.csv"
 
 	avcMetarCsvFieldRawText         string = "raw_text"
 	avcMetarCsvFieldStationID       string = "station_id"
 	avcMetarCsvFieldObservationTime string = "observation_time"
 	avcMetarCsvFieldMetarType       string = "metar_type"
 
-	avcTafCsvFieldRawText     string = "raw_text"
-	avcTafCsvFieldStationID   string = "station_id"
-	avcTafCsvFieldValidTimeTo string = "valid_time_to"
+	avcTafCsvFieldRawText       string = "raw_text"
+	avcTafCsvFieldStationID     string = "station_id"
+	avcTafCsvFieldValidTimeFrom string = "valid_time_from"
+	avcTafCsvFieldValidTimeTo   string = "valid_time_to"
+	avcTafCsvFieldIssueTime     string = "issue_time"
 )
 
 const (
-	ourairportsAirportsCsv  string = "https://ourairports.com/data/airports.csv"
-	ourairportsCountriesCsv string = "https://ourairports.com/data/countries.csv"
-	ourairportsRegionsCsv   string = "https://ourairports.com/data/regions.csv"
+	defaultOurAirportsAirportsURL  string = "https://ourairports.com/data/airports.csv"
+	defaultOurAirportsCountriesURL string = "https://ourairports.com/data/countries.csv"
+	defaultOurAirportsRegionsURL   string = "https://ourairports.com/data/regions.csv"
 
 	ourairportsAirportsCsvFieldType         string = "type"
 	ourairportsAirportsCsvFieldName         string = "name"
@@ -46,6 +58,41 @@ const (
 	ourairportsAirportsCsvFieldIsoRegion    string = "iso_region"
 	ourairportsAirportsCsvFieldMunicipality string = "municipality"
 	ourairportsAirportsCsvFieldGpsCode      string = "gps_code"
+
+	ourairportsAirportsTypeClosed string = "closed"
+
+	ourairportsCountriesCsvFieldCode string = "code"
+	ourairportsCountriesCsvFieldName string = "name"
+)
+
+const (
+	// Reports longer than this are considered malformed, used unless
+	// UpdateContext specifies other limits
+	defaultMaxMetarLength = 1024
+	defaultMaxTafLength   = 4096
+
+	defaultImportProgressInterval = 10000
+
+	// Malformed CSV records are skipped unless there are more of them
+	// than this, used unless UpdateContext specifies other limit
+	defaultMaxBadRecords = 100
+)
+
+// errShortRecord means that CSV record does not have all required fields
+var errShortRecord = errors.New("Record has too few fields")
+
+// Reports are retreived every minute so only short retries are worth it;
+// location data are retreived once a day and may be retried longer
+var (
+	avcRetry         = util.RetryPolicy{MaxAttempts: 3, BaseDelay: 5 * time.Second}
+	ourairportsRetry = util.RetryPolicy{MaxAttempts: 5, BaseDelay: 30 * time.Second}
+	snapshotRetry    = util.RetryPolicy{MaxAttempts: 3, BaseDelay: 5 * time.Second}
+)
+
+const (
+	// Snapshot does not contain report times, so reports imported from the
+	// snapshot expire after this period unless updated from the upstream
+	snapshotReportExpire int64 = 3600 * 3
 )
 
 // UpdateContext is passed to endpoint handlers
@@ -53,27 +100,139 @@ type UpdateContext struct {
 	Db                database.Database
 	MetarsLastUpdated time.Time
 	TafsLastUpdated   time.Time
-	Log               log.Logger
+	// Log is the logger for update progress and errors; util.StdLogger is
+	// used if nil
+	Log util.Logger
+
+	// MetarsLastCount and TafsLastCount are the numbers of reports updated
+	// during previous update cycle
+	MetarsLastCount int
+	TafsLastCount   int
+	// CoverageWarnFraction triggers a warning when the number of updated
+	// reports falls below this fraction of the previous cycle's number; zero
+	// disables the check
+	CoverageWarnFraction float64
+	// CoverageWarnWebhook is an optional URL where the warning is POSTed
+	CoverageWarnWebhook string
+
+	// MaxMetarLength and MaxTafLength are maximum report lengths; longer
+	// reports are skipped; defaults are used if zero
+	MaxMetarLength int
+	MaxTafLength   int
+
+	// MaxBadRecords is the maximum number of malformed records skipped in
+	// a single CSV file; reading the file stops once there are more
+	// malformed records; default is used if zero
+	MaxBadRecords int
+
+	// ImportProgressInterval is the number of records after which the
+	// progress of OurAirports import is reported; default is used if zero
+	ImportProgressInterval int
+	// OnImportProgress is optionally called along with progress logging
+	// with the number of records processed so far and the elapsed time
+	OnImportProgress func(records int, elapsed time.Duration)
+
+	// DisableSanitization disables removing of non-printable characters
+	// and extra whitespace from the reports before storing them
+	DisableSanitization bool
+
+	// IngestOnlyStations, if specified, limits stored METARs and TAFs to the
+	// reports for these stations; all reports are stored if empty
+	IngestOnlyStations []string
+
+	// MetarURL and TafURL are the locations of METAR and TAF CSV feeds;
+	// aviationweather.gov feeds are used if empty
+	MetarURL string
+	TafURL   string
+	// OurAirportsAirportsURL, OurAirportsCountriesURL and
+	// OurAirportsRegionsURL are the locations of OurAirports CSV files;
+	// ourairports.com files are used if empty
+	OurAirportsAirportsURL  string
+	OurAirportsCountriesURL string
+	OurAirportsRegionsURL   string
+}
+
+func logger(ctx *UpdateContext) util.Logger {
+	return util.LoggerOrDefault(ctx.Log)
+}
+
+// withLog returns the retry policy logging to the context's logger
+func withLog(ctx *UpdateContext, retry util.RetryPolicy) util.RetryPolicy {
+	retry.Log = logger(ctx)
+	return retry
+}
+
+// sourceURLs returns the URLs of all data sources, replacing unspecified
+// ones with defaults
+func sourceURLs(ctx *UpdateContext) map[string]string {
+	return map[string]string{
+		"METAR":                 urlOrDefault(ctx.MetarURL, defaultMetarURL),
+		"TAF":                   urlOrDefault(ctx.TafURL, defaultTafURL),
+		"OurAirports airports":  urlOrDefault(ctx.OurAirportsAirportsURL, defaultOurAirportsAirportsURL),
+		"OurAirports countries": urlOrDefault(ctx.OurAirportsCountriesURL, defaultOurAirportsCountriesURL),
+		"OurAirports regions":   urlOrDefault(ctx.OurAirportsRegionsURL, defaultOurAirportsRegionsURL),
+	}
+}
+
+func urlOrDefault(u string, def string) string {
+	if len(u) == 0 {
+		return def
+	}
+	return u
 }
 
-// UpdateMetars retreives METAR data from aviationweather.gov
-func UpdateMetars(ctx *UpdateContext) {
-	log.Println("Updating METARs")
+// ValidateURLs checks that the URLs of all data sources specified in the
+// context are well-formed absolute http or https URLs.
+func ValidateURLs(ctx *UpdateContext) error {
+	sources := sourceURLs(ctx)
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		u, err := url.Parse(sources[name])
+		if err != nil {
+			return fmt.Errorf("Invalid %s URL: %s", name, err.Error())
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			return fmt.Errorf("Invalid %s URL %s: must be absolute http or https URL",
+				name, sources[name])
+		}
+	}
+	return nil
+}
+
+// UpdateMetars retreives METAR data from aviationweather.gov and returns
+// the number of updated, skipped and failed METARs.
+// The METARs are collected while the CSV is read and stored with a single
+// SetMETARs call before returning, so the update in progress must be
+// completed rather than abandoned on shutdown, otherwise the collected
+// METARs are lost.
+func UpdateMetars(ctx *UpdateContext) UpdateStats {
+	var stats UpdateStats
+	logger(ctx).Info("Updating METARs")
 	start := time.Now()
-	metars, err := util.GetFromURL(avcMetarURL, ctx.MetarsLastUpdated)
+	metarURL := urlOrDefault(ctx.MetarURL, defaultMetarURL)
+	metars, err := util.GetFromURL(metarURL, ctx.MetarsLastUpdated, withLog(ctx, avcRetry))
 	if err != nil {
-		log.Printf("Error retreiving %s: %s", avcMetarURL, err.Error())
-		return
+		logger(ctx).Error("Error retreiving %s: %s", metarURL, err.Error())
+		return stats
 	}
 	if metars == nil {
-		log.Printf("METARs not updated since last update")
-		return
+		logger(ctx).Info("METARs not updated since last update")
+		return stats
 	}
 	defer metars.Close()
 	ctx.MetarsLastUpdated = time.Now()
-	log.Printf("Downloaded METARs in %v", time.Now().Sub(start))
+	logger(ctx).Info("Downloaded METARs in %v", time.Now().Sub(start))
 
-	start, num := time.Now(), 0
+	start = time.Now()
+	// Summary is logged on every exit path, including failed read
+	defer func() {
+		logger(ctx).Info("Updated %d METARs in %v, %d skipped, %d failed",
+			stats.Updated, time.Now().Sub(start), stats.Skipped, stats.Failed)
+	}()
 	r := csv.NewReader(metars)
 	fieldNames := []string{
 		avcMetarCsvFieldRawText,
@@ -82,13 +241,13 @@ func UpdateMetars(ctx *UpdateContext) {
 		avcMetarCsvFieldMetarType}
 	fieldIdx, err := util.ParseCsvHeader(r, fieldNames)
 	if err != nil {
-		log.Printf("Error parsing header of METARs CSV %s", err.Error())
-		return
+		logger(ctx).Error("Error parsing header of METARs CSV %s", err.Error())
+		return stats
 	}
 	for i, idx := range fieldIdx {
 		if idx < 0 {
-			log.Printf("Field %s not found in METAR CSV", fieldNames[i])
-			return
+			logger(ctx).Error("Field %s not found in METAR CSV", fieldNames[i])
+			return stats
 		}
 	}
 	colRawText := fieldIdx[0]
@@ -96,109 +255,410 @@ func UpdateMetars(ctx *UpdateContext) {
 	colObsTime := fieldIdx[2]
 	colType := fieldIdx[3]
 
+	maxMetarLength := ctx.MaxMetarLength
+	if maxMetarLength == 0 {
+		maxMetarLength = defaultMaxMetarLength
+	}
+	stations := ingestStations(ctx)
+
+	var entries []database.MetarEntry
+	// The CSV may contain several reports for a station in any order, so
+	// only the report with the newest observation time is stored
+	stationEntry := make(map[string]int)
+	readFailed := false
+	badRecords := 0
 	for {
 		record, err := r.Read()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			log.Printf("Error reading METAR CSV: %s : %v", err.Error(), record)
-			return
+			if skipBadRecord(ctx, "METAR CSV", &badRecords, err, record) {
+				continue
+			}
+			// Still store the METARs read so far
+			readFailed = true
+			break
+		}
+		if stations != nil && !stations[record[colStation]] {
+			continue
 		}
 		expire, err := util.ExpireSeconds(record[colObsTime], 3600*3)
-		if err != nil {
-			log.Printf("Cannot parse METAR time %s: %s",
+		if err == util.ErrExpired {
+			logger(ctx).Debug("METAR for %s observed at %s is already expired",
+				record[colStation], record[colObsTime])
+		} else if err != nil {
+			logger(ctx).Warn("Cannot parse METAR time %s: %s",
 				record[colObsTime], err.Error())
 		}
-		metar := record[colType] + " " + record[colRawText]
-		err = ctx.Db.SetMETAR(record[colStation], metar, expire)
-		if err != nil {
-			log.Printf("Cannot update METAR %s (expires in %d sec): %s",
-				metar, expire, err.Error())
+		// Only special reports are stored with the report type, so that
+		// the type is known when the report is served
+		metar := sanitize(ctx, record[colRawText])
+		if record[colType] == wxtypes.MetarTypeSpeci {
+			metar = wxtypes.MetarTypeSpeci + " " + metar
 		}
-		num++
+		if len(metar) > maxMetarLength {
+			logger(ctx).Warn("Skipping METAR for %s of length %d exceeding %d",
+				record[colStation], len(metar), maxMetarLength)
+			stats.Skipped++
+			continue
+		}
+		// Parse error is already logged above, zero time means unknown
+		obsTime, _ := util.ParseReportTime(record[colObsTime])
+		entry := database.MetarEntry{
+			Location: record[colStation],
+			Metar:    metar,
+			ObsTime:  obsTime,
+			Expire:   expire,
+		}
+		if i, ok := stationEntry[entry.Location]; ok {
+			if obsTime.Before(entries[i].ObsTime) {
+				continue
+			}
+			entries[i] = entry
+			continue
+		}
+		stationEntry[entry.Location] = len(entries)
+		entries = append(entries, entry)
+	}
+	stats.Updated = len(entries)
+	stats.Skipped += badRecords
+	if err := ctx.Db.SetMETARs(entries); err != nil {
+		logger(ctx).Error("Cannot update some of %d METARs: %s", len(entries), err.Error())
+		// It is not known which of the METARs were not updated
+		stats.Updated, stats.Failed = 0, len(entries)
+	}
+	if readFailed {
+		return stats
 	}
-	log.Printf("Updated %d METARs in %v", num, time.Now().Sub(start))
+	num := len(entries)
+	checkCoverage(ctx, "METARs", ctx.MetarsLastCount, num)
+	ctx.MetarsLastCount = num
+	return stats
 }
 
-// UpdateTafs retreives TAF data from avaitionweather.gov
-func UpdateTafs(ctx *UpdateContext) {
-	log.Println("Updating TAFs")
+// parseTafTime parses start or end of TAF validity period in the column col
+// of the record. Returns zero time if the column is missing or the time
+// cannot be parsed.
+func parseTafTime(ctx *UpdateContext, record []string, col int) time.Time {
+	if col < 0 || col >= len(record) || len(record[col]) == 0 {
+		return time.Time{}
+	}
+	t, err := util.ParseReportTime(record[col])
+	if err != nil {
+		logger(ctx).Warn("Cannot parse TAF time %s: %s", record[col], err.Error())
+		return time.Time{}
+	}
+	return t
+}
+
+// UpdateTafs retreives TAF data from avaitionweather.gov and returns the
+// number of updated, skipped and failed TAFs.
+// The TAFs are collected and stored the same way as by UpdateMetars.
+func UpdateTafs(ctx *UpdateContext) UpdateStats {
+	var stats UpdateStats
+	logger(ctx).Info("Updating TAFs")
 	start := time.Now()
-	tafs, err := util.GetFromURL(avcTafURL, ctx.TafsLastUpdated)
+	tafURL := urlOrDefault(ctx.TafURL, defaultTafURL)
+	tafs, err := util.GetFromURL(tafURL, ctx.TafsLastUpdated, withLog(ctx, avcRetry))
 	if err != nil {
-		log.Printf("Error retreiving TAFs %s: %s", avcTafURL, err.Error())
-		return
+		logger(ctx).Error("Error retreiving TAFs %s: %s", tafURL, err.Error())
+		return stats
 	}
 	if tafs == nil {
-		log.Printf("TAFs not updated since last update")
-		return
+		logger(ctx).Info("TAFs not updated since last update")
+		return stats
 	}
 	defer tafs.Close()
 	ctx.TafsLastUpdated = time.Now()
-	log.Printf("Downloaded TAFs in %v", time.Now().Sub(start))
+	logger(ctx).Info("Downloaded TAFs in %v", time.Now().Sub(start))
 
-	start, num := time.Now(), 0
+	start = time.Now()
+	// Summary is logged on every exit path, including failed read
+	defer func() {
+		logger(ctx).Info("Updated %d TAFs in %v, %d skipped, %d failed",
+			stats.Updated, time.Now().Sub(start), stats.Skipped, stats.Failed)
+	}()
 	r := csv.NewReader(tafs)
 	fieldNames := []string{
 		avcTafCsvFieldRawText,
 		avcTafCsvFieldStationID,
-		avcTafCsvFieldValidTimeTo}
+		avcTafCsvFieldValidTimeTo,
+		avcTafCsvFieldValidTimeFrom,
+		avcTafCsvFieldIssueTime}
 	fieldIdx, err := util.ParseCsvHeader(r, fieldNames)
 	if err != nil {
-		log.Printf("Error parsing header of TAFs CSV %s", err.Error())
-		return
+		logger(ctx).Error("Error parsing header of TAFs CSV %s", err.Error())
+		return stats
 	}
-	for i, idx := range fieldIdx {
+	colRawText, colStation, colTimeTo, colTimeFrom, colIssueTime :=
+		fieldIdx[0], fieldIdx[1], fieldIdx[2], fieldIdx[3], fieldIdx[4]
+	// All fields except the last two are required
+	for i, idx := range fieldIdx[:len(fieldIdx)-2] {
 		if idx < 0 {
-			log.Printf("Field %s not found in TAFs CSV", fieldNames[i])
-			return
+			logger(ctx).Error("Field %s not found in TAFs CSV", fieldNames[i])
+			return stats
 		}
 	}
-	colRawText, colStation, colTimeTo := fieldIdx[0], fieldIdx[1], fieldIdx[2]
+	if colTimeFrom < 0 {
+		// Older feeds do not have the start of validity period
+		logger(ctx).Warn("Field %s not found in TAFs CSV", avcTafCsvFieldValidTimeFrom)
+	}
+	if colIssueTime < 0 {
+		logger(ctx).Warn("Field %s not found in TAFs CSV", avcTafCsvFieldIssueTime)
+	}
 	r.FieldsPerRecord = -1
 
+	maxTafLength := ctx.MaxTafLength
+	if maxTafLength == 0 {
+		maxTafLength = defaultMaxTafLength
+	}
+	stations := ingestStations(ctx)
+
+	var entries []database.TafEntry
+	readFailed := false
+	badRecords := 0
+	// Number of fields varies, so the records are checked for the required
+	// fields
+	minFields := maxColumn(colRawText, colStation, colTimeTo) + 1
 	for {
 		record, err := r.Read()
+		if err == nil && len(record) < minFields {
+			err = errShortRecord
+		}
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			log.Printf("Error reading TAFs CSV: %s : %v", err.Error(), record)
-			return
+			if skipBadRecord(ctx, "TAFs CSV", &badRecords, err, record) {
+				continue
+			}
+			// Still store the TAFs read so far
+			readFailed = true
+			break
+		}
+		if stations != nil && !stations[record[colStation]] {
+			continue
 		}
 		expire, err := util.ExpireSeconds(record[colTimeTo], 0)
-		if err != nil {
-			log.Printf("Cannot parse TAFs time 'to' %s: %s",
+		if err == util.ErrExpired {
+			logger(ctx).Debug("TAF for %s valid to %s is already expired",
+				record[colStation], record[colTimeTo])
+		} else if err != nil {
+			logger(ctx).Warn("Cannot parse TAFs time 'to' %s: %s",
 				record[colTimeTo], err.Error())
 		}
-		err = ctx.Db.SetTAF(record[colStation], record[colRawText], expire)
+		validTo := parseTafTime(ctx, record, colTimeTo)
+		validFrom := parseTafTime(ctx, record, colTimeFrom)
+		issueTime := parseTafTime(ctx, record, colIssueTime)
+		taf := sanitize(ctx, record[colRawText])
+		if len(taf) > maxTafLength {
+			logger(ctx).Warn("Skipping TAF for %s of length %d exceeding %d",
+				record[colStation], len(taf), maxTafLength)
+			stats.Skipped++
+			continue
+		}
+		entries = append(entries, database.TafEntry{
+			Location:  record[colStation],
+			Taf:       taf,
+			ValidFrom: validFrom,
+			ValidTo:   validTo,
+			IssueTime: issueTime,
+			Expire:    expire,
+		})
+	}
+	stats.Updated = len(entries)
+	stats.Skipped += badRecords
+	if err := ctx.Db.SetTAFs(entries); err != nil {
+		logger(ctx).Error("Cannot update some of %d TAFs: %s", len(entries), err.Error())
+		// It is not known which of the TAFs were not updated
+		stats.Updated, stats.Failed = 0, len(entries)
+	}
+	if readFailed {
+		return stats
+	}
+	num := len(entries)
+	checkCoverage(ctx, "TAFs", ctx.TafsLastCount, num)
+	ctx.TafsLastCount = num
+	return stats
+}
+
+// ingestStations returns the set of stations whose reports are stored or nil
+// if reports for all stations are stored.
+func ingestStations(ctx *UpdateContext) map[string]bool {
+	if len(ctx.IngestOnlyStations) == 0 {
+		return nil
+	}
+	stations := make(map[string]bool, len(ctx.IngestOnlyStations))
+	for _, s := range ctx.IngestOnlyStations {
+		stations[strings.ToUpper(s)] = true
+	}
+	return stations
+}
+
+// sanitize cleans up a report string unless disabled in UpdateContext.
+func sanitize(ctx *UpdateContext, report string) string {
+	if ctx.DisableSanitization {
+		return report
+	}
+	return util.SanitizeReport(report)
+}
+
+// checkCoverage warns if the number of reports updated during current cycle
+// dropped sharply compared to the previous cycle, which usually indicates an
+// upstream outage.
+func checkCoverage(ctx *UpdateContext, reports string, prevNum int, num int) {
+	if ctx.CoverageWarnFraction <= 0 || prevNum == 0 {
+		return
+	}
+	if float64(num) >= float64(prevNum)*ctx.CoverageWarnFraction {
+		return
+	}
+	msg := fmt.Sprintf("number of updated %s dropped from %d to %d",
+		reports, prevNum, num)
+	logger(ctx).Warn("%s", msg)
+	if len(ctx.CoverageWarnWebhook) == 0 {
+		return
+	}
+	body, err := json.Marshal(map[string]string{"text": "WARNING: " + msg})
+	if err != nil {
+		logger(ctx).Error("Error converting warning to JSON: %s", err.Error())
+		return
+	}
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	resp, err := httpClient.Post(ctx.CoverageWarnWebhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		logger(ctx).Error("Error posting warning to %s: %s", ctx.CoverageWarnWebhook, err.Error())
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		logger(ctx).Warn("Posting warning to %s resulted in code %d", ctx.CoverageWarnWebhook, resp.StatusCode)
+	}
+}
+
+// UpdateStats holds the results of a single update of METARs, TAFs or
+// locations.
+type UpdateStats struct {
+	// Updated is the number of records stored in the database
+	Updated int
+	// Skipped is the number of malformed records which were not stored
+	Skipped int
+	// Failed is the number of records which could not be stored in the
+	// database
+	Failed int
+}
+
+// skipBadRecord logs the error of reading CSV record and checks whether the
+// record may be skipped and reading continued. Reading stops on errors other
+// than malformed records, or once there are more than MaxBadRecords
+// malformed records.
+func skipBadRecord(ctx *UpdateContext, csvName string, badRecords *int, err error, record []string) bool {
+	if _, ok := err.(*csv.ParseError); !ok && err != errShortRecord {
+		logger(ctx).Error("Error reading %s: %s : %v", csvName, err.Error(), record)
+		return false
+	}
+	maxBadRecords := ctx.MaxBadRecords
+	if maxBadRecords == 0 {
+		maxBadRecords = defaultMaxBadRecords
+	}
+	*badRecords++
+	if *badRecords > maxBadRecords {
+		logger(ctx).Error("Error reading %s: %s : %v, more than %d malformed records",
+			csvName, err.Error(), record, maxBadRecords)
+		return false
+	}
+	logger(ctx).Warn("Skipping malformed record of %s: %s : %v", csvName, err.Error(), record)
+	return true
+}
+
+// maxColumn returns the largest of CSV column indices.
+func maxColumn(cols ...int) int {
+	result := -1
+	for _, c := range cols {
+		if c > result {
+			result = c
+		}
+	}
+	return result
+}
+
+// getOurAirportsCountries retreives the names of the countries from
+// ourairports.com as map of ISO country codes to country names. Returns
+// empty map if the names cannot be retreived, so that the locations are
+// still imported without country names.
+func getOurAirportsCountries(ctx *UpdateContext) map[string]string {
+	names := make(map[string]string)
+	countriesURL := urlOrDefault(ctx.OurAirportsCountriesURL, defaultOurAirportsCountriesURL)
+	countries, err := util.GetFromURL(countriesURL, time.Unix(0, 0), withLog(ctx, ourairportsRetry))
+	if err != nil {
+		logger(ctx).Error("Error retreiving OurAirports country database %s: %s", countriesURL, err.Error())
+		return names
+	}
+	if countries == nil {
+		return names
+	}
+	defer countries.Close()
+	r := csv.NewReader(countries)
+	fieldNames := []string{
+		ourairportsCountriesCsvFieldCode,
+		ourairportsCountriesCsvFieldName}
+	fieldIdx, err := util.ParseCsvHeader(r, fieldNames)
+	if err != nil {
+		logger(ctx).Error("Error parsing header of ourairports country CSV %s", err.Error())
+		return names
+	}
+	for i, idx := range fieldIdx {
+		if idx < 0 {
+			logger(ctx).Error("Field %s not found in ourairports country CSV", fieldNames[i])
+			return names
+		}
+	}
+	colCode, colName := fieldIdx[0], fieldIdx[1]
+	badRecords := 0
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			log.Printf("Cannot update METAR %s (expires in %d sec): %s",
-				record[colRawText], expire, err.Error())
+			if skipBadRecord(ctx, "ourairports country CSV", &badRecords, err, record) {
+				continue
+			}
+			break
 		}
-		num++
+		names[record[colCode]] = record[colName]
 	}
-	log.Printf("Updated %d TAFs in %v", num, time.Now().Sub(start))
+	logger(ctx).Info("Retreived %d country names from OurAirports", len(names))
+	return names
 }
 
 // GetFromOurAirports imports station data for ICAO locations from
-// ourairports.com
-func GetFromOurAirports(ctx *UpdateContext) {
-	log.Println("Importing from OurAirports")
+// ourairports.com and returns the number of updated, skipped and failed
+// locations.
+func GetFromOurAirports(ctx *UpdateContext) UpdateStats {
+	var stats UpdateStats
+	logger(ctx).Info("Importing from OurAirports")
+	countryNames := getOurAirportsCountries(ctx)
 	start := time.Now()
-	airports, err := util.GetFromURL(ourairportsAirportsCsv, time.Unix(0, 0))
+	airportsURL := urlOrDefault(ctx.OurAirportsAirportsURL, defaultOurAirportsAirportsURL)
+	airports, err := util.GetFromURL(airportsURL, time.Unix(0, 0), withLog(ctx, ourairportsRetry))
 	if err != nil {
-		log.Printf("Error retreiving OurAirports airport database %s: %s", ourairportsAirportsCsv, err.Error())
-		return
+		logger(ctx).Error("Error retreiving OurAirports airport database %s: %s", airportsURL, err.Error())
+		return stats
 	}
 	if airports == nil {
-		log.Printf("OurAirports airport database not updated since last update")
-		return
+		logger(ctx).Info("OurAirports airport database not updated since last update")
+		return stats
 	}
 	defer airports.Close()
-	log.Printf("Downloaded Airports database in %v", time.Now().Sub(start))
-	start, num := time.Now(), 0
+	logger(ctx).Info("Downloaded Airports database in %v", time.Now().Sub(start))
+	start = time.Now()
+	// Summary is logged on every exit path, including failed read
+	defer func() {
+		logger(ctx).Info("Updated %d locations from ourairport database in %v, %d skipped, %d failed",
+			stats.Updated, time.Now().Sub(start), stats.Skipped, stats.Failed)
+	}()
 	r := csv.NewReader(airports)
 	fieldNames := []string{
 		ourairportsAirportsCsvFieldType,
@@ -212,61 +672,296 @@ func GetFromOurAirports(ctx *UpdateContext) {
 		ourairportsAirportsCsvFieldGpsCode}
 	fieldIdx, err := util.ParseCsvHeader(r, fieldNames)
 	if err != nil {
-		log.Printf("Error parsing header of ourairports airport CSV %s", err.Error())
-		return
+		logger(ctx).Error("Error parsing header of ourairports airport CSV %s", err.Error())
+		return stats
 	}
 	for i, idx := range fieldIdx {
 		if idx < 0 {
-			log.Printf("Field %s not found in ourairports airport CSV", fieldNames[i])
-			return
+			logger(ctx).Error("Field %s not found in ourairports airport CSV", fieldNames[i])
+			return stats
 		}
 	}
 	colType, colName := fieldIdx[0], fieldIdx[1]
 	colLat, colLon, colAlt := fieldIdx[2], fieldIdx[3], fieldIdx[4]
-	colCountryCode /*colRegionCode,*/, colCity := fieldIdx[5] /*fieldIdx[6],*/, fieldIdx[7]
+	colCountryCode, colRegionCode, colCity := fieldIdx[5], fieldIdx[6], fieldIdx[7]
 	colICAOCode := fieldIdx[8]
 
+	progressInterval := ctx.ImportProgressInterval
+	if progressInterval == 0 {
+		progressInterval = defaultImportProgressInterval
+	}
+	records, badRecords := 0, 0
+	complete := false
+
 	for {
 		record, err := r.Read()
 		if err == io.EOF {
+			complete = true
 			break
 		}
+		records++
+		if records%progressInterval == 0 {
+			elapsed := time.Now().Sub(start)
+			logger(ctx).Info("Processed %d records of ourairports airport CSV (%.0f records/sec)",
+				records, float64(records)/elapsed.Seconds())
+			if ctx.OnImportProgress != nil {
+				ctx.OnImportProgress(records, elapsed)
+			}
+		}
 		if err != nil {
-			log.Printf("Error reading ourairports airport CSV: %s : %v", err.Error(), record)
-			return
+			if skipBadRecord(ctx, "ourairports airport CSV", &badRecords, err, record) {
+				continue
+			}
+			// Locations imported so far are already stored
+			break
 		}
 
-		if record[colType] != "closed" && util.ValidateICAOLocation(record[colICAOCode]) {
+		if util.ValidateICAOLocation(record[colICAOCode]) {
 			alt, erralt := strconv.Atoi(record[colAlt])
 			if erralt != nil {
-				log.Printf("Atoi error %s parsing %s in %v", erralt.Error(), record[colICAOCode], record)
+				logger(ctx).Warn("Atoi error %s parsing %s in %v", erralt.Error(), record[colICAOCode], record)
 			}
 			lat, errlat := strconv.ParseFloat(record[colLat], 64)
 			if errlat != nil {
-				log.Printf("ParseFloat error %s parsing %s in %v", errlat.Error(), record[colLat], record)
+				logger(ctx).Warn("ParseFloat error %s parsing %s in %v", errlat.Error(), record[colLat], record)
 			}
 			lon, errlon := strconv.ParseFloat(record[colLon], 64)
 			if errlon != nil {
-				log.Printf("ParseFloat error %s parsing %s in %v", errlon.Error(), record[colLon], record)
+				logger(ctx).Warn("ParseFloat error %s parsing %s in %v", errlon.Error(), record[colLon], record)
 			}
 			if erralt == nil && errlat == nil && errlon == nil {
-				dl := database.DataICAOLocation{
+				dl := wxtypes.DataICAOLocation{
 					Location:     record[colICAOCode],
 					Name:         record[colName],
 					City:         record[colCity],
 					CountryCode:  record[colCountryCode],
+					CountryName:  countryNames[record[colCountryCode]],
+					Region:       record[colRegionCode],
 					Latitude:     lat,
 					Longitude:    lon,
 					AltitudeFeet: alt,
+					Timezone:     util.LocationTimezone(record[colCountryCode], record[colRegionCode]),
+					Closed:       record[colType] == ourairportsAirportsTypeClosed,
 				}
 				err = ctx.Db.SetDataICAOLocation(&dl)
 				if err != nil {
-					log.Printf("Cannot set ICAO location %v: %s", record, err.Error())
+					logger(ctx).Error("Cannot set ICAO location %v: %s", record, err.Error())
+					stats.Failed++
+				} else {
+					stats.Updated++
 				}
-				num++
+			} else {
+				stats.Skipped++
+			}
+		}
+
+	}
+	stats.Skipped += badRecords
+	if stats.Updated == 0 {
+		// Location data did not change
+		return stats
+	}
+	if !complete {
+		// Only a full import changes the dataset
+		logger(ctx).Warn("Import of ourairports airport CSV is incomplete, data epoch not incremented")
+		return stats
+	}
+	epoch, err := ctx.Db.IncrementDataEpoch()
+	if err != nil {
+		logger(ctx).Error("Cannot increment data epoch: %s", err.Error())
+		return stats
+	}
+	logger(ctx).Info("Data epoch is now %d", epoch)
+	return stats
+}
+
+// ImportSnapshot imports location data along with METARs and TAFs from a
+// JSON snapshot. The snapshot is either a JSON array of DataICAOLocation,
+// i.e. the same format as served by the 'all' endpoint, or newline-delimited
+// JSON with one DataICAOLocation per line, as written by ExportSnapshot. Src
+// is either a local file path or http(s) URL.
+func ImportSnapshot(ctx *UpdateContext, src string) {
+	logger(ctx).Info("Importing snapshot %s", src)
+	start := time.Now()
+	var snapshot io.ReadCloser
+	var err error
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		snapshot, err = util.GetFromURL(src, time.Unix(0, 0), withLog(ctx, snapshotRetry))
+	} else {
+		snapshot, err = os.Open(src)
+	}
+	if err != nil {
+		logger(ctx).Error("Error retreiving snapshot %s: %s", src, err.Error())
+		return
+	}
+	if snapshot == nil {
+		logger(ctx).Info("Snapshot %s not updated since last update", src)
+		return
+	}
+	defer snapshot.Close()
+
+	r := bufio.NewReader(snapshot)
+	dec := json.NewDecoder(r)
+	if isJSONArray(r) {
+		// Consume opening bracket, so that the elements are decoded one
+		// by one
+		if _, err := dec.Token(); err != nil {
+			logger(ctx).Error("Error decoding snapshot %s: %s", src, err.Error())
+			return
+		}
+	}
+
+	var metars []database.MetarEntry
+	var tafs []database.TafEntry
+	num, skipped := 0, 0
+	for dec.More() {
+		var d *wxtypes.DataICAOLocation
+		if err := dec.Decode(&d); err != nil {
+			logger(ctx).Error("Error decoding snapshot %s: %s", src, err.Error())
+			// Still store the reports read so far
+			break
+		}
+		if d == nil || !util.ValidateICAOLocation(d.Location) {
+			skipped++
+			continue
+		}
+		// Location data from the snapshot may be outdated
+		if err := ctx.Db.SetDataICAOLocationIfAbsent(d); err != nil {
+			logger(ctx).Error("Cannot set ICAO location %v: %s", d, err.Error())
+			skipped++
+			continue
+		}
+		d.Metar, d.Taf = sanitize(ctx, d.Metar), sanitize(ctx, d.Taf)
+		if len(d.Metar) > 0 {
+			// Special reports are stored with the report type, which is
+			// served separately
+			metar := d.Metar
+			if d.MetarType == wxtypes.MetarTypeSpeci && !strings.HasPrefix(metar, wxtypes.MetarTypeSpeci+" ") {
+				metar = wxtypes.MetarTypeSpeci + " " + metar
 			}
+			// Zero time means unknown observation time
+			obsTime, _ := time.Parse(time.RFC3339, d.MetarObservationTime)
+			metars = append(metars, database.MetarEntry{
+				Location: d.Location,
+				Metar:    metar,
+				ObsTime:  obsTime,
+				Expire:   snapshotReportExpire,
+			})
+		}
+		if len(d.Taf) > 0 {
+			// Zero time means unknown validity period or issue time
+			validFrom, _ := time.Parse(time.RFC3339, d.TafValidFrom)
+			validTo, _ := time.Parse(time.RFC3339, d.TafValidTo)
+			issueTime, _ := time.Parse(time.RFC3339, d.TafIssueTime)
+			tafs = append(tafs, database.TafEntry{
+				Location:  d.Location,
+				Taf:       d.Taf,
+				ValidFrom: validFrom,
+				ValidTo:   validTo,
+				IssueTime: issueTime,
+				Expire:    snapshotReportExpire,
+			})
+		}
+		num++
+	}
+	if err := ctx.Db.SetMETARs(metars); err != nil {
+		logger(ctx).Error("Cannot update some of %d METARs: %s", len(metars), err.Error())
+	}
+	if err := ctx.Db.SetTAFs(tafs); err != nil {
+		logger(ctx).Error("Cannot update some of %d TAFs: %s", len(tafs), err.Error())
+	}
+	logger(ctx).Info("Imported %d locations from snapshot in %v, %d invalid entries skipped",
+		num, time.Now().Sub(start), skipped)
+}
+
+// isJSONArray checks whether the first non-whitespace character is opening
+// bracket. Whitespace is consumed.
+func isJSONArray(r *bufio.Reader) bool {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return false
 		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			r.UnreadByte()
+			return b == '['
+		}
+	}
+}
+
+// SnapshotFormat is the format of the snapshot written by ExportSnapshot
+type SnapshotFormat int
+
+const (
+	// SnapshotJSON is a JSON array of DataICAOLocation
+	SnapshotJSON SnapshotFormat = iota
+	// SnapshotNDJSON is newline-delimited JSON with one DataICAOLocation
+	// per line
+	SnapshotNDJSON
+)
 
+// exportBatchSize is the number of locations retreived at once by
+// ExportSnapshot
+const exportBatchSize = 500
+
+// ExportSnapshot writes location data along with current METARs and TAFs of
+// all locations as a snapshot which can be imported with ImportSnapshot.
+// The locations are retreived and written in batches, so the snapshot is
+// not held in memory. Returns the number of exported locations.
+func ExportSnapshot(ctx *UpdateContext, w io.Writer, format SnapshotFormat) (int, error) {
+	logger(ctx).Info("Exporting snapshot")
+	start := time.Now()
+	enc := json.NewEncoder(w)
+	num := 0
+	if format == SnapshotJSON {
+		if _, err := io.WriteString(w, "["); err != nil {
+			return num, err
+		}
+	}
+	// The same location may be listed more than once
+	exported := make(map[string]bool)
+	var cursor uint64
+	for {
+		loc, next, err := ctx.Db.ListLocations("", cursor, exportBatchSize)
+		if err != nil {
+			return num, fmt.Errorf("Unable to list locations: %s", err.Error())
+		}
+		var batch []string
+		for _, l := range loc {
+			if !exported[l] {
+				exported[l] = true
+				batch = append(batch, l)
+			}
+		}
+		if len(batch) > 0 {
+			ld, err := ctx.Db.GetICAOLocationData(batch)
+			if err != nil {
+				return num, fmt.Errorf("Unable to retreive data for %d locations: %s", len(batch), err.Error())
+			}
+			for _, d := range ld {
+				wxtypes.SplitMetarType(d)
+				if format == SnapshotJSON && num > 0 {
+					if _, err := io.WriteString(w, ","); err != nil {
+						return num, err
+					}
+				}
+				// Encoder terminates each location with newline
+				if err := enc.Encode(d); err != nil {
+					return num, err
+				}
+				num++
+			}
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	if format == SnapshotJSON {
+		if _, err := io.WriteString(w, "]\n"); err != nil {
+			return num, err
+		}
 	}
-	log.Printf("Updated %d locations from ourairport database in %v", num, time.Now().Sub(start))
+	logger(ctx).Info("Exported %d locations in %v", num, time.Now().Sub(start))
+	return num, nil
 }
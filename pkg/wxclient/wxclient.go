@@ -0,0 +1,185 @@
+/*
+* Copyright (C) 2020 Nick Naumenko (https://gitlab.com/nnaumenko)
+* All rights reserved.
+* This software may be modified and distributed under the terms
+* of the MIT license. See the LICENSE file for details.
+ */
+
+// Package wxclient is a client for JSON REST API weather service provided
+// by wx-server.
+package wxclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/nnaumenko/wx/pkg/wxtypes"
+)
+
+const (
+	endpointMetar    string = "metar"
+	endpointTaf      string = "taf"
+	endpointLocation string = "location"
+	endpointAll      string = "all"
+	endpointNearest  string = "nearest"
+
+	paramLocation  string = "location"
+	paramLatitude  string = "lat"
+	paramLongitude string = "lon"
+	paramCount     string = "count"
+)
+
+// ErrNoData is returned when the API responds with 204 No Content, i.e. the
+// location exists but there is no data to serve.
+var ErrNoData = errors.New("No data for the location")
+
+// Error is returned when the API responds with a status code other than
+// 200 OK or 204 No Content.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("wx API error %d %s: %s",
+		e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Client performs requests to the API located at BaseURL.
+type Client struct {
+	BaseURL    *url.URL
+	HTTPClient *http.Client
+}
+
+// NewClient is a factory function to create an instance of Client.
+// BaseURL is the URL of the API root, e.g. "https://wx.void.fo".
+// If httpClient is nil, http.DefaultClient is used.
+func NewClient(baseURL string, httpClient *http.Client) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse base URL %s: %s", baseURL, err)
+	}
+	if len(u.Scheme) == 0 || len(u.Host) == 0 {
+		return nil, fmt.Errorf("Base URL %s must be absolute", baseURL)
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{BaseURL: u, HTTPClient: httpClient}, nil
+}
+
+// GetMETAR retreives current METAR for a single ICAO location. ErrNoData is
+// returned if the API is configured to respond with 204 No Content when
+// there is no METAR.
+func (c *Client) GetMETAR(ctx context.Context, icao string) (*wxtypes.DataICAOLocation, error) {
+	return c.getSingle(ctx, endpointMetar, icao)
+}
+
+// GetTAF retreives current TAF for a single ICAO location. ErrNoData is
+// returned if the API is configured to respond with 204 No Content when
+// there is no TAF.
+func (c *Client) GetTAF(ctx context.Context, icao string) (*wxtypes.DataICAOLocation, error) {
+	return c.getSingle(ctx, endpointTaf, icao)
+}
+
+// GetLocation retreives location info for a single ICAO location.
+func (c *Client) GetLocation(ctx context.Context, icao string) (*wxtypes.DataICAOLocation, error) {
+	return c.getSingle(ctx, endpointLocation, icao)
+}
+
+// GetAll retreives location info along with current METAR and TAF for one
+// or more ICAO locations. Locations not found by the API are not included
+// in the slice.
+func (c *Client) GetAll(ctx context.Context, icaos ...string) ([]*wxtypes.DataICAOLocation, error) {
+	return c.getMultiple(ctx, endpointAll, icaos)
+}
+
+// Nearest retreives location info for up to limit ICAO locations nearest to
+// the specified latitude and longitude, sorted by distance. The API default
+// is used if limit is zero.
+func (c *Client) Nearest(ctx context.Context, lat float64, lon float64, limit int) ([]*wxtypes.DataICAOLocation, error) {
+	q := url.Values{}
+	q.Set(paramLatitude, strconv.FormatFloat(lat, 'f', -1, 64))
+	q.Set(paramLongitude, strconv.FormatFloat(lon, 'f', -1, 64))
+	if limit > 0 {
+		q.Set(paramCount, strconv.Itoa(limit))
+	}
+	var result []*wxtypes.DataICAOLocation
+	err := c.get(ctx, endpointNearest, q.Encode(), &result)
+	if err == ErrNoData {
+		return make([]*wxtypes.DataICAOLocation, 0), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (c *Client) getSingle(ctx context.Context, endpoint string, icao string) (*wxtypes.DataICAOLocation, error) {
+	if len(icao) == 0 {
+		return nil, errors.New("Location not specified")
+	}
+	var result wxtypes.DataICAOLocation
+	err := c.get(ctx, endpoint+"/"+url.PathEscape(icao), "", &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *Client) getMultiple(ctx context.Context, endpoint string, icaos []string) ([]*wxtypes.DataICAOLocation, error) {
+	if len(icaos) == 0 {
+		return nil, errors.New("Location not specified")
+	}
+	q := url.Values{}
+	q.Set(paramLocation, strings.Join(icaos, ","))
+	var result []*wxtypes.DataICAOLocation
+	err := c.get(ctx, endpoint, q.Encode(), &result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (c *Client) get(ctx context.Context, path string, query string, v interface{}) error {
+	u := *c.BaseURL
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + path
+	u.RawQuery = query
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return ErrNoData
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		// Error message is served as wxtypes.ErrorResponse, older server
+		// versions serve it as plain text
+		var e wxtypes.ErrorResponse
+		if err := json.Unmarshal(msg, &e); err == nil && len(e.Error) > 0 {
+			return &Error{StatusCode: resp.StatusCode, Message: e.Error}
+		}
+		return &Error{
+			StatusCode: resp.StatusCode,
+			Message:    strings.TrimSpace(string(msg)),
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("Unable to decode response from %s: %s", u.String(), err)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+/*
+* Copyright (C) 2020 Nick Naumenko (https://gitlab.com/nnaumenko)
+* All rights reserved.
+* This software may be modified and distributed under the terms
+* of the MIT license. See the LICENSE file for details.
+ */
+
+// Package wxtypes contains the data types shared by wx-server responses and
+// the API clients.
+package wxtypes
+
+import "strings"
+
+// DataICAOLocation is the data for a single location
+// designated by an ICAO location code.
+// Has JSON and XML tags to be marshalled easily.
+type DataICAOLocation struct {
+	Location       string  `json:"location,omitempty" xml:"location,omitempty"`
+	Metar          string  `json:"metar,omitempty" xml:"metar,omitempty"`
+	Taf            string  `json:"taf,omitempty" xml:"taf,omitempty"`
+	Name           string  `json:"name,omitempty" xml:"name,omitempty"`
+	City           string  `json:"city,omitempty" xml:"city,omitempty"`
+	CountryCode    string  `json:"country_code,omitempty" xml:"country_code,omitempty"`
+	CountryName    string  `json:"country_name,omitempty" xml:"country_name,omitempty"`
+	Region         string  `json:"region,omitempty" xml:"region,omitempty"`
+	Latitude       float64 `json:"latitude,omitempty" xml:"latitude,omitempty"`
+	Longitude      float64 `json:"longitude,omitempty" xml:"longitude,omitempty"`
+	AltitudeMeters int     `json:"altitude_meters,omitempty" xml:"altitude_meters,omitempty"`
+	AltitudeFeet   int     `json:"altitude_feet,omitempty" xml:"altitude_feet,omitempty"`
+	Timezone       string  `json:"timezone,omitempty" xml:"timezone,omitempty"`
+	Closed         bool    `json:"closed,omitempty" xml:"closed,omitempty"`
+	NoData         bool    `json:"no_data,omitempty" xml:"no_data,omitempty"`
+	// MetarType is the type of METAR report, MetarTypeMetar for routine
+	// report or MetarTypeSpeci for special report
+	MetarType string `json:"metar_type,omitempty" xml:"metar_type,omitempty"`
+	// MetarObservationTime is the time when METAR observation was taken in
+	// RFC3339 format, empty if not known
+	MetarObservationTime string `json:"metar_observation_time,omitempty" xml:"metar_observation_time,omitempty"`
+	// TafValidFrom and TafValidTo are the start and the end of TAF validity
+	// period in RFC3339 format, empty if not known
+	TafValidFrom string `json:"taf_valid_from,omitempty" xml:"taf_valid_from,omitempty"`
+	TafValidTo   string `json:"taf_valid_to,omitempty" xml:"taf_valid_to,omitempty"`
+	// TafIssueTime is the time when TAF was issued in RFC3339 format, empty
+	// if not known
+	TafIssueTime string `json:"taf_issue_time,omitempty" xml:"taf_issue_time,omitempty"`
+	// TafAgeBasis is the time the age of TAF is computed from, either
+	// TafAgeBasisIssue or TafAgeBasisValidity; only set along with TAF
+	TafAgeBasis string `json:"taf_age_basis,omitempty" xml:"taf_age_basis,omitempty"`
+	// TafAgeSeconds is the time elapsed since TafIssueTime or TafValidFrom,
+	// according to TafAgeBasis; nil if the time is not known
+	TafAgeSeconds *int64 `json:"taf_age_seconds,omitempty" xml:"taf_age_seconds,omitempty"`
+	// TafExpired is true if TAF is no longer current, only set if the
+	// server is configured to serve expired TAFs
+	TafExpired bool `json:"taf_expired,omitempty" xml:"taf_expired,omitempty"`
+	// DistanceKm is the distance to the location in kilometers, only
+	// initialised in the results of nearest locations query
+	DistanceKm float64 `json:"distance_km,omitempty" xml:"distance_km,omitempty"`
+}
+
+// Types of METAR reports
+const (
+	MetarTypeMetar = "METAR"
+	MetarTypeSpeci = "SPECI"
+)
+
+// SplitMetarType removes the report type from the beginning of METAR as
+// stored in the database and sets MetarType instead. METARs without report
+// type are routine reports.
+func SplitMetarType(ld *DataICAOLocation) {
+	if len(ld.Metar) == 0 {
+		return
+	}
+	ld.MetarType = MetarTypeMetar
+	for _, t := range []string{MetarTypeMetar, MetarTypeSpeci} {
+		if strings.HasPrefix(ld.Metar, t+" ") {
+			ld.MetarType = t
+			ld.Metar = ld.Metar[len(t)+1:]
+			return
+		}
+	}
+}
+
+// Times the age of TAF is computed from
+const (
+	TafAgeBasisIssue    = "issue"
+	TafAgeBasisValidity = "validity"
+)
+
+// ErrorResponse is served instead of the data if the request failed. Status
+// is the same as HTTP status code of the response.
+// Has JSON tags to be marshalled easily.
+type ErrorResponse struct {
+	Error  string `json:"error"`
+	Status int    `json:"status"`
+}
+
+// DensityAltitude is the density altitude at a location calculated from
+// location's elevation and current METAR.
+// Has JSON tags to be marshalled easily.
+type DensityAltitude struct {
+	Location             string  `json:"location"`
+	Metar                string  `json:"metar"`
+	ElevationFeet        int     `json:"elevation_feet"`
+	TemperatureCelsius   float64 `json:"temperature_celsius"`
+	AltimeterInHg        float64 `json:"altimeter_inhg"`
+	PressureAltitudeFeet int     `json:"pressure_altitude_feet"`
+	DensityAltitudeFeet  int     `json:"density_altitude_feet"`
+}
+
+// Consistency reports whether current METAR was observed within the
+// validity period of current TAF. Times are in RFC3339 format and are empty
+// if the report is missing or the time is not known. WithinTafValidity and
+// the gaps are null unless both reports along with their times are known.
+// Has JSON tags to be marshalled easily.
+type Consistency struct {
+	Location             string `json:"location"`
+	MetarMissing         bool   `json:"metar_missing"`
+	TafMissing           bool   `json:"taf_missing"`
+	MetarObservationTime string `json:"metar_observation_time,omitempty"`
+	TafValidFrom         string `json:"taf_valid_from,omitempty"`
+	TafValidTo           string `json:"taf_valid_to,omitempty"`
+	WithinTafValidity    *bool  `json:"within_taf_validity"`
+	// SinceTafValidFromSeconds is the time from the start of TAF validity
+	// period to METAR observation, negative if METAR was observed before
+	// the start
+	SinceTafValidFromSeconds *int64 `json:"since_taf_valid_from_seconds"`
+	// UntilTafValidToSeconds is the time from METAR observation to the end
+	// of TAF validity period, negative if METAR was observed after the end
+	UntilTafValidToSeconds *int64 `json:"until_taf_valid_to_seconds"`
+}
+
+// DataStats holds the number of locations and current reports stored in the
+// database.
+// Has JSON tags to be marshalled easily.
+type DataStats struct {
+	Locations int `json:"locations"`
+	Metars    int `json:"metars"`
+	Tafs      int `json:"tafs"`
+}
+
+// LocationsRequest is the body of POST request to metar, taf, location or
+// all endpoint, used instead of URL query for large number of locations.
+// Has JSON tags to be marshalled easily.
+type LocationsRequest struct {
+	Locations []string `json:"locations"`
+}
+
+// BatchRequest is a single sub-request of a batch request. Endpoint is one of
+// metar, taf, location or all.
+// Has JSON tags to be marshalled easily.
+type BatchRequest struct {
+	Endpoint  string   `json:"endpoint"`
+	Locations []string `json:"locations"`
+}
+
+// BatchResponse is the response to a single sub-request of a batch request.
+// If the sub-request failed, Error holds the error message and Data is
+// empty.
+// Has JSON tags to be marshalled easily.
+type BatchResponse struct {
+	Endpoint string              `json:"endpoint"`
+	Data     []*DataICAOLocation `json:"data"`
+	Error    string              `json:"error,omitempty"`
+}